@@ -0,0 +1,98 @@
+package rtree
+
+import (
+	"github.com/maja42/vmath"
+)
+
+// Entry stores an arbitrary payload together with its bounding box.
+// It allows storing values that do not implement the Item interface themselves.
+type Entry struct {
+	Rect    vmath.Rectf // normalized bounding box
+	Payload interface{}
+}
+
+// Bounds returns the bounding box of the entry.
+func (e *Entry) Bounds() vmath.Rectf {
+	return e.Rect
+}
+
+// NewFromEntries creates a new RTree and bulk-loads the given entries.
+// The entries are stored as *Entry items. Use Payloads to extract the payloads from query results.
+func NewFromEntries(entries []Entry) *RTree {
+	items := make([]Item, len(entries))
+	for i := range entries {
+		entry := entries[i]
+		entry.Rect = entry.Rect.Normalize()
+		items[i] = &entry
+	}
+	return New().BulkLoad(items)
+}
+
+// InsertEntry adds a single payload with the given bounding box.
+// Returns the inserted entry, which can be used to remove the payload again.
+func (r *RTree) InsertEntry(bounds vmath.Rectf, payload interface{}) *Entry {
+	entry := &Entry{
+		Rect:    bounds.Normalize(),
+		Payload: payload,
+	}
+	r.Insert(entry)
+	return entry
+}
+
+// Payloads returns the payloads of all entries within the given items.
+// Items that are not entries are skipped.
+func Payloads(items []Item) []interface{} {
+	payloads := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if entry, ok := item.(*Entry); ok {
+			payloads = append(payloads, entry.Payload)
+		}
+	}
+	return payloads
+}
+
+// IndexEntry associates a bounding box with an index, for example into an external slice.
+type IndexEntry struct {
+	Rect  vmath.Rectf // normalized bounding box
+	Index int
+}
+
+// Bounds returns the bounding box of the entry.
+func (e *IndexEntry) Bounds() vmath.Rectf {
+	return e.Rect
+}
+
+// InsertIndex adds a bounding box that is associated with the given index.
+// The index is stored as an *IndexEntry item. Use SearchIndices to query indices directly.
+func (r *RTree) InsertIndex(bounds vmath.Rectf, index int) *RTree {
+	return r.Insert(&IndexEntry{
+		Rect:  bounds.Normalize(),
+		Index: index,
+	})
+}
+
+// RemoveIndex removes the entry with the given bounding box and index.
+// In lazy mode, the removal is deferred until the next query (see SetLazy).
+func (r *RTree) RemoveIndex(bounds vmath.Rectf, index int) *RTree {
+	bounds = bounds.Normalize()
+	return r.Remove(&IndexEntry{Rect: bounds, Index: index}, func(a, b Item) bool {
+		other, ok := b.(*IndexEntry)
+		return ok && other.Index == index && other.Rect == bounds
+	})
+}
+
+// SearchIndices returns the indices of all index entries within the area.
+// Items that are not index entries are skipped.
+// If mustCover is true, entries are only returned if they are fully within the search area.
+// If false, entries are returned if they intersect the search area.
+func (r *RTree) SearchIndices(area vmath.Rectf, mustCover bool) []int {
+	r.flush()
+	var indices []int
+	r.iterateSearch(area, mustCover, func(item Item) bool {
+		if entry, ok := item.(*IndexEntry); ok {
+			indices = append(indices, entry.Index)
+		}
+		return false
+	})
+	return indices
+}
@@ -0,0 +1,89 @@
+package rtree
+
+import (
+	"testing"
+
+	"github.com/maja42/vmath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFromEntries(t *testing.T) {
+	entries := make([]Entry, 100)
+	for i := range entries {
+		entries[i] = Entry{
+			Rect:    randomRect(),
+			Payload: []int{i}, // not comparable
+		}
+	}
+	tree := NewFromEntries(entries)
+	assert.Equal(t, len(entries), tree.Size())
+
+	all := Payloads(tree.All())
+	assert.Len(t, all, len(entries))
+	for _, entry := range entries {
+		assert.Contains(t, all, entry.Payload)
+	}
+
+	entry := tree.InsertEntry(vmath.Rectf{Min: vmath.Vec2f{-10, -10}, Max: vmath.Vec2f{-9, -9}}, "payload")
+	found := Payloads(tree.SearchPos(vmath.Vec2f{-9.5, -9.5}))
+	assert.Equal(t, []interface{}{"payload"}, found)
+
+	tree.Remove(entry, nil)
+	assert.Equal(t, len(entries), tree.Size())
+
+	entry = tree.InsertEntry(vmath.Rectf{Min: vmath.Vec2f{-9, -9}, Max: vmath.Vec2f{-10, -10}}, "inverted")
+	assert.Equal(t, vmath.Rectf{Min: vmath.Vec2f{-10, -10}, Max: vmath.Vec2f{-9, -9}}, entry.Rect)
+	found = Payloads(tree.SearchPos(vmath.Vec2f{-9.5, -9.5}))
+	assert.Equal(t, []interface{}{"inverted"}, found)
+}
+
+func TestSearchIndices(t *testing.T) {
+	rects := make([]vmath.Rectf, 1000)
+	tree := New()
+	for i := range rects {
+		rects[i] = randomRect()
+		tree.InsertIndex(rects[i], i)
+	}
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{10, 10},
+		Max: vmath.Vec2f{60, 40},
+	}
+
+	var expected []int
+	for i, rect := range rects {
+		if area.ContainsRectf(rect) {
+			expected = append(expected, i)
+		}
+	}
+	assert.ElementsMatch(t, expected, tree.SearchIndices(area, true))
+
+	tree.RemoveIndex(rects[expected[0]], expected[0])
+	assert.Equal(t, len(rects)-1, tree.Size())
+	tree.RemoveIndex(rects[expected[0]], expected[0])
+	assert.Equal(t, len(rects)-1, tree.Size())
+	assert.ElementsMatch(t, expected[1:], tree.SearchIndices(area, true))
+}
+
+func TestRemoveIndex_Inverted(t *testing.T) {
+	rect := vmath.Rectf{Min: vmath.Vec2f{5, 5}, Max: vmath.Vec2f{1, 1}}
+	tree := New().InsertIndex(rect, 7)
+	assert.Equal(t, []int{7}, tree.SearchIndices(rect.Normalize(), true))
+
+	tree.RemoveIndex(rect, 7)
+	assert.Equal(t, 0, tree.Size())
+}
+
+func TestRemoveIndex_Lazy(t *testing.T) {
+	rect := vmath.Rectf{Min: vmath.Vec2f{1, 1}, Max: vmath.Vec2f{5, 5}}
+	tree := New().SetLazy(true)
+	tree.InsertIndex(rect, 1).InsertIndex(rect, 2)
+	tree.RemoveIndex(rect, 1)
+	assert.Equal(t, 3, tree.PendingOps())
+
+	assert.Equal(t, []int{2}, tree.SearchIndices(rect, false))
+	assert.Equal(t, 0, tree.PendingOps())
+
+	tree.RemoveIndex(rect, 2)
+	assert.Equal(t, 1, tree.PendingOps())
+	assert.Empty(t, tree.SearchIndices(rect, false))
+}
@@ -0,0 +1,31 @@
+package rtree
+
+// Freeze marks the tree as read-only.
+// All operations that modify the tree panic until Unfreeze is called.
+// Operations that were deferred in lazy mode are applied beforehand.
+//
+// Read-only queries can be executed concurrently on a frozen tree.
+// Freezing documents (and enforces) that no modifications happen during that time.
+func (r *RTree) Freeze() *RTree {
+	r.flush()
+	r.frozen = true
+	return r
+}
+
+// Unfreeze allows modifications after the tree was frozen (see Freeze).
+func (r *RTree) Unfreeze() *RTree {
+	r.frozen = false
+	return r
+}
+
+// Frozen returns true if the tree is read-only (see Freeze).
+func (r *RTree) Frozen() bool {
+	return r.frozen
+}
+
+// checkWritable panics if the tree is frozen.
+func (r *RTree) checkWritable() {
+	if r.frozen {
+		panic("rtree: cannot modify frozen tree")
+	}
+}
@@ -0,0 +1,42 @@
+package rtree
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/maja42/vmath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreeze(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	tree.SetLazy(true)
+	tree.Insert(randomItem())
+	tree.Freeze()
+	assert.True(t, tree.Frozen())
+	assert.Equal(t, 0, tree.PendingOps())
+
+	msg := "rtree: cannot modify frozen tree"
+	assert.PanicsWithValue(t, msg, func() { tree.Insert(randomItem()) })
+	assert.PanicsWithValue(t, msg, func() { tree.Remove(items[0], nil) })
+	assert.PanicsWithValue(t, msg, func() { tree.MarkRemoved(items[0], nil) })
+	assert.PanicsWithValue(t, msg, func() { tree.BulkLoad(randomItems(100)) })
+	assert.PanicsWithValue(t, msg, func() { tree.Clear() })
+	assert.PanicsWithValue(t, msg, func() { tree.Translate(vmath.Vec2f{1, 1}) })
+	assert.Equal(t, 1001, tree.Size())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Len(t, tree.All(), 1001)
+		}()
+	}
+	wg.Wait()
+
+	tree.Unfreeze()
+	assert.False(t, tree.Frozen())
+	tree.Remove(items[0], nil)
+	assert.Equal(t, 1000, tree.Size())
+}
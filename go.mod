@@ -1,10 +1,16 @@
 module github.com/maja42/rtree
 
-go 1.14
+go 1.23
 
 require (
 	github.com/maja42/vmath v0.2.1
 	github.com/stretchr/testify v1.5.1
 )
 
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
+
 // replace github.com/maja42/vmath => ../vmath
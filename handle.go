@@ -0,0 +1,68 @@
+package rtree
+
+// Handle references an item that was inserted with InsertTracked.
+// It remembers the leaf node containing the item, so that removal doesn't need to compare the items of other leaves.
+type Handle struct {
+	item Item
+	leaf *node
+}
+
+// Item returns the referenced item.
+func (h Handle) Item() Item {
+	return h.item
+}
+
+// InsertTracked adds a single item, like Insert, and returns a handle for removal (see RemoveHandle).
+// In lazy mode, all pending operations are applied and the item is inserted immediately.
+func (r *RTree) InsertTracked(item Item) Handle {
+	r.flush()
+	leaf := r.insert(item)
+	if indexOfItem(leaf.items, item, nil) < 0 { // leaf was split
+		leaf, _, _ = r.findItem(item, nil)
+	}
+	return Handle{
+		item: item,
+		leaf: leaf,
+	}
+}
+
+// RemoveHandle removes the item referenced by the handle.
+// Returns false if the item is not part of the tree.
+//
+// Instead of searching for the item, the removal locates the remembered leaf node and skips the items of all other leaves.
+// This is not a constant-time operation: Finding the path to the leaf still descends into every node that contains the leaf's bounds.
+// Handles become outdated when the item is moved into another leaf node,
+// which happens if the leaf is split during subsequent insertions, and on Rebuild, BulkRepack and similar operations.
+// Outdated handles are still valid, but removal falls back to a regular search.
+func (r *RTree) RemoveHandle(h Handle) bool {
+	r.checkWritable()
+	r.flush()
+	if h.leaf != nil {
+		if idx := indexOfItem(h.leaf.items, h.item, nil); idx >= 0 {
+			if path := findNodePath(r.root.Load(), h.leaf, nil); path != nil {
+				h.leaf.items = append(h.leaf.items[:idx], h.leaf.items[idx+1:]...)
+				r.condense(path)
+				r.version.Add(1)
+				return true
+			}
+		}
+	}
+	return r.remove(h.item, nil)
+}
+
+// findNodePath returns the path from the root of the subtree to the target node (including both).
+// Returns nil if the target is not part of the subtree.
+func findNodePath(nod *node, target *node, path []*node) []*node {
+	path = append(path, nod)
+	if nod == target {
+		return path
+	}
+	for _, child := range nod.children {
+		if child.height >= target.height && child.bounds.ContainsRectf(target.bounds) {
+			if targetPath := findNodePath(child, target, path); targetPath != nil {
+				return targetPath
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package rtree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveHandle(t *testing.T) {
+	tree := New()
+	items := randomItems(500)
+
+	handles := make([]Handle, len(items))
+	for i, item := range items {
+		handles[i] = tree.InsertTracked(item)
+		assert.Equal(t, item, handles[i].Item())
+	}
+	assert.Equal(t, len(items), tree.Size())
+
+	for i, h := range handles {
+		assert.True(t, tree.RemoveHandle(h))
+		assert.Equal(t, len(items)-i-1, tree.Size())
+	}
+	assert.Empty(t, tree.All())
+
+	// removing again fails
+	assert.False(t, tree.RemoveHandle(handles[0]))
+}
+
+func TestRemoveHandle_Outdated(t *testing.T) {
+	tree := New()
+	items := randomItems(100)
+
+	handles := make([]Handle, len(items))
+	for i, item := range items {
+		handles[i] = tree.InsertTracked(item)
+	}
+	tree.BulkRepack(nil)
+
+	for _, h := range handles {
+		assert.True(t, tree.RemoveHandle(h))
+	}
+	assert.Equal(t, 0, tree.Size())
+}
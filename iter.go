@@ -0,0 +1,109 @@
+package rtree
+
+import (
+	"iter"
+	"sort"
+
+	"github.com/maja42/vmath"
+	"github.com/maja42/vmath/math32"
+)
+
+// Items returns an iterator over all stored items.
+// The order in which items are iterated is undefined.
+func (r *RTree) Items() iter.Seq[Item] {
+	return func(yield func(Item) bool) {
+		r.flush()
+		iterateAllItems(r.root.Load(), func(item Item) bool {
+			return !yield(item)
+		})
+	}
+}
+
+// ItemsInArea returns an iterator over all items within the area.
+// If mustCover is true, items are only returned if they are fully within the search area.
+// If false, items are returned if they intersect the search area.
+// The order in which items are iterated is undefined.
+func (r *RTree) ItemsInArea(area vmath.Rectf, mustCover bool) iter.Seq[Item] {
+	return func(yield func(Item) bool) {
+		r.flush()
+		r.iterateSearch(area, mustCover, func(item Item) bool {
+			return !yield(item)
+		})
+	}
+}
+
+// AllSorted returns all stored items, sorted by the given comparator.
+// If 'less' is nil, the items are sorted by their bounds: by min. x, min. y, max. x and max. y.
+// Only items with equal bounds keep the (structure-dependent) order in which they are stored.
+func (r *RTree) AllSorted(less func(a, b Item) bool) []Item {
+	if less == nil {
+		less = func(a, b Item) bool {
+			return lessByBounds(a.Bounds(), b.Bounds())
+		}
+	}
+	items := r.All()
+	sort.SliceStable(items, func(i, j int) bool {
+		return less(items[i], items[j])
+	})
+	return items
+}
+
+// IterateSortedX calls the provided function for every stored item until true (=abort) is returned.
+// Items are iterated in ascending order of their min. x-coordinate.
+// Since the tree is not sorted globally, all items are collected and sorted first, which costs O(n log n).
+func (r *RTree) IterateSortedX(fn func(item Item) bool) {
+	items := r.All()
+	sort.Sort(itemsByMinX(items))
+	for _, item := range items {
+		if fn(item) {
+			return
+		}
+	}
+}
+
+// RangeX returns all items whose x-interval overlaps with [minX, maxX].
+// The items are sorted in ascending order of their min. x-coordinate.
+// If the tree keeps its leaves sorted (see SetSortedLeaves), leaves are binary-searched instead of testing every item.
+func (r *RTree) RangeX(minX, maxX float32) []Item {
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{minX, math32.NegInfinity},
+		Max: vmath.Vec2f{maxX, math32.Infinity},
+	}
+	var items []Item
+	if r.sortedLeaves {
+		r.flush()
+		items = r.rangeXSorted(area.Normalize())
+	} else {
+		items = r.Search(area, false)
+	}
+	sort.Sort(itemsByMinX(items))
+	return items
+}
+
+// rangeXSorted returns all items whose x-interval overlaps with the area's x-interval.
+// Requires the items of all leaves to be sorted by their min. x-coordinate.
+func (r *RTree) rangeXSorted(area vmath.Rectf) []Item {
+	minX, maxX := area.Min[0], area.Max[0]
+	var items []Item
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		if node.bounds.Max[0] < minX || node.bounds.Min[0] > maxX {
+			continue
+		}
+		nodesToSearch = append(nodesToSearch, node.children...)
+
+		// all items after 'end' start right of the range
+		end := sort.Search(len(node.items), func(i int) bool {
+			return node.items[i].Bounds().Min[0] > maxX
+		})
+		for _, item := range node.items[:end] {
+			if !isTombstone(item) && item.Bounds().Max[0] >= minX {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
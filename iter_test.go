@@ -0,0 +1,141 @@
+package rtree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/maja42/vmath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestItemsInArea(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{20, 30},
+		Max: vmath.Vec2f{40, 60},
+	}
+
+	for _, mustCover := range []bool{false, true} {
+		var items []Item
+		for item := range tree.ItemsInArea(area, mustCover) {
+			items = append(items, item)
+		}
+		assert.ElementsMatch(t, tree.Search(area, mustCover), items)
+	}
+
+	cnt := 0
+	for range tree.Items() {
+		cnt++
+		if cnt == 10 {
+			break
+		}
+	}
+	assert.Equal(t, 10, cnt)
+}
+
+func TestIterateSortedX(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+
+	var items []Item
+	tree.IterateSortedX(func(item Item) bool {
+		items = append(items, item)
+		return false
+	})
+	assert.Len(t, items, 1000)
+	assert.True(t, sort.IsSorted(itemsByMinX(items)))
+
+	items = tree.RangeX(20, 25)
+	assert.True(t, sort.IsSorted(itemsByMinX(items)))
+	for _, item := range items {
+		assert.LessOrEqual(t, item.Bounds().Min[0], float32(25))
+		assert.GreaterOrEqual(t, item.Bounds().Max[0], float32(20))
+	}
+	assert.Len(t, items, len(tree.Search(vmath.Rectf{
+		Min: vmath.Vec2f{20, 0},
+		Max: vmath.Vec2f{25, 100},
+	}, false)))
+}
+
+func TestRangeX_SortedLeaves(t *testing.T) {
+	items := randomItems(1000)
+	tree := New().SetSortedLeaves(true)
+	tree.BulkLoad(items[:500])
+	for _, item := range items[500:] {
+		tree.Insert(item)
+	}
+	for _, item := range items[:100] {
+		tree.Remove(item, nil)
+	}
+	assertLeavesSorted(t, tree.root.Load())
+
+	unsorted := New().BulkLoad(items[100:])
+	for _, r := range [][2]float32{{20, 25}, {0, 100}, {50, 50}, {30, 10}, {-10, -5}} {
+		assert.ElementsMatch(t, unsorted.RangeX(r[0], r[1]), tree.RangeX(r[0], r[1]))
+	}
+
+	// enabling the option sorts existing leaves
+	assertLeavesSorted(t, unsorted.SetSortedLeaves(true).root.Load())
+}
+
+func assertLeavesSorted(t *testing.T, root *node) {
+	nodesToSearch := []*node{root}
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+		assert.True(t, sort.IsSorted(itemsByMinX(node.items)))
+	}
+}
+
+func BenchmarkRangeX(b *testing.B) {
+	tree, _ := newPrePopulatedTree(testTreeSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		minX := rand.Float32() * 100
+		_ = tree.RangeX(minX, minX+1)
+	}
+}
+
+func BenchmarkRangeX_SortedLeaves(b *testing.B) {
+	tree, _ := newPrePopulatedTree(testTreeSize)
+	tree.SetSortedLeaves(true)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		minX := rand.Float32() * 100
+		_ = tree.RangeX(minX, minX+1)
+	}
+}
+
+func TestAllSorted(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+
+	sorted := tree.AllSorted(nil)
+	assert.ElementsMatch(t, items, sorted)
+	assert.True(t, sort.SliceIsSorted(sorted, func(i, j int) bool {
+		return lessByBounds(sorted[i].Bounds(), sorted[j].Bounds())
+	}))
+
+	// the order doesn't depend on the tree structure
+	assert.Equal(t, sorted, New().BulkLoad(items).AllSorted(nil))
+
+	// items with the same min. corner are ordered by their max. corner
+	pos := vmath.Vec2f{10, 10}
+	sameMin := []Item{
+		&testItem{bounds: vmath.Rectf{Min: pos, Max: vmath.Vec2f{30, 20}}},
+		&testItem{bounds: vmath.Rectf{Min: pos, Max: vmath.Vec2f{20, 30}}},
+		&testItem{bounds: vmath.Rectf{Min: pos, Max: vmath.Vec2f{20, 20}}},
+	}
+	tree = New()
+	for _, item := range sameMin {
+		tree.Insert(item)
+	}
+	assert.Equal(t, []Item{sameMin[2], sameMin[1], sameMin[0]}, tree.AllSorted(nil))
+
+	byMaxY := func(a, b Item) bool {
+		return a.Bounds().Max[1] < b.Bounds().Max[1]
+	}
+	sorted = tree.AllSorted(byMaxY)
+	assert.True(t, sort.SliceIsSorted(sorted, func(i, j int) bool {
+		return byMaxY(sorted[i], sorted[j])
+	}))
+}
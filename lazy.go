@@ -0,0 +1,67 @@
+package rtree
+
+// pendingOp is an insertion or removal that was deferred in lazy mode.
+type pendingOp struct {
+	item     Item
+	remove   bool
+	equalsFn EqualsFunc
+}
+
+// SetLazy enables or disables the lazy mode.
+//
+// In lazy mode, Insert and Remove only record the operation instead of modifying the tree.
+// The first subsequent query (or any other operation) applies all pending operations at once by rebuilding the tree.
+// This is much faster for bursts of modifications, but the first query on a modified tree pays the rebuild costs.
+// Note that queries modify the tree in lazy mode and must not be executed concurrently.
+//
+// Disabling the lazy mode applies all pending operations.
+func (r *RTree) SetLazy(lazy bool) *RTree {
+	if !lazy {
+		r.flush()
+	}
+	r.lazy = lazy
+	return r
+}
+
+// PendingOps returns the number of insertions and removals that were deferred in lazy mode.
+func (r *RTree) PendingOps() int {
+	return len(r.pending)
+}
+
+// Flush applies all operations that were deferred in lazy mode.
+func (r *RTree) Flush() *RTree {
+	r.flush()
+	return r
+}
+
+// Rebuild repacks the whole tree by bulk-loading all items into a new tree.
+// All operations that were deferred in lazy mode are applied as well.
+// The bounds of all items are read again, so Rebuild can also be used after the items' Bounds method
+// started to return different bounds (for example, after switching between coordinate systems).
+func (r *RTree) Rebuild() *RTree {
+	r.checkWritable()
+	pending := r.pending
+	r.pending = nil
+
+	// Removals are applied to the tree directly, unless they affect items that are pending for insertion.
+	var inserts []Item
+	for _, op := range pending {
+		if !op.remove {
+			inserts = append(inserts, op.item)
+			continue
+		}
+		if idx := indexOfItem(inserts, op.item, op.equalsFn); idx >= 0 {
+			inserts = append(inserts[:idx], inserts[idx+1:]...)
+			continue
+		}
+		r.remove(op.item, op.equalsFn)
+	}
+	return r.BulkRepack(inserts)
+}
+
+// flush applies all pending operations if there are any.
+func (r *RTree) flush() {
+	if len(r.pending) > 0 {
+		r.Rebuild()
+	}
+}
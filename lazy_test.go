@@ -0,0 +1,32 @@
+package rtree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLazy(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	tree.SetLazy(true)
+
+	inserted := randomItems(100)
+	for _, item := range inserted {
+		tree.Insert(item)
+	}
+	for _, item := range items[:50] {
+		tree.Remove(item, nil)
+	}
+	tree.Remove(inserted[0], nil)
+	assert.Equal(t, 151, tree.PendingOps())
+
+	expected := append(items[50:], inserted[1:]...)
+	assert.ElementsMatch(t, expected, tree.All())
+	assert.Equal(t, 0, tree.PendingOps())
+
+	tree.Insert(inserted[0])
+	assert.Equal(t, 1, tree.PendingOps())
+	tree.SetLazy(false)
+	assert.Equal(t, 0, tree.PendingOps())
+	assert.Equal(t, len(expected)+1, tree.Size())
+}
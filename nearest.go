@@ -0,0 +1,600 @@
+package rtree
+
+import (
+	"container/heap"
+	"runtime"
+	"sync"
+
+	"github.com/maja42/vmath"
+	"github.com/maja42/vmath/math32"
+)
+
+// DistanceFunc returns the distance between a position and the closest point within the given bounds.
+// The distance must be 0 if the position is within the bounds.
+type DistanceFunc func(pos vmath.Vec2f, bounds vmath.Rectf) float32
+
+// EuclideanDistance returns the euclidean distance between a position and the given bounds.
+func EuclideanDistance(pos vmath.Vec2f, bounds vmath.Rectf) float32 {
+	return bounds.PointDistance(pos)
+}
+
+// ManhattanDistance returns the manhattan distance (L1 norm) between a position and the given bounds.
+func ManhattanDistance(pos vmath.Vec2f, bounds vmath.Rectf) float32 {
+	d := axisDistances(pos, bounds)
+	return d[0] + d[1]
+}
+
+// ChebyshevDistance returns the chebyshev distance (L∞ norm) between a position and the given bounds.
+func ChebyshevDistance(pos vmath.Vec2f, bounds vmath.Rectf) float32 {
+	d := axisDistances(pos, bounds)
+	return math32.Max(d[0], d[1])
+}
+
+// axisDistances returns the distance between a position and the given bounds along each axis.
+func axisDistances(pos vmath.Vec2f, bounds vmath.Rectf) vmath.Vec2f {
+	var d vmath.Vec2f
+	for dim, val := range pos {
+		if val < bounds.Min[dim] {
+			d[dim] = bounds.Min[dim] - val
+		} else if val > bounds.Max[dim] {
+			d[dim] = val - bounds.Max[dim]
+		}
+	}
+	return d
+}
+
+// NNQuery describes a nearest neighbor search with optional constraints.
+type NNQuery struct {
+	Pos         vmath.Vec2f  // Query position
+	K           int          // Maximum number of returned items. 0 means unbounded.
+	MaxDistance float32      // Maximum distance of returned items. 0 means unbounded.
+	Filter      FilterFunc   // Optional. If 'filter' returns false, the item is discarded.
+	Metric      DistanceFunc // Optional. Defaults to EuclideanDistance.
+}
+
+// NearestNeighbors returns the items closest to the query position that satisfy all constraints of the query.
+// The items are sorted by their distance, starting with the closest one.
+func (r *RTree) NearestNeighbors(query NNQuery) []Item {
+	r.flush()
+	k := query.K
+	if k <= 0 {
+		k = maxInt
+	}
+	maxDist := query.MaxDistance
+	if maxDist <= 0 {
+		maxDist = math32.Infinity
+	}
+
+	if query.Metric == nil { // compare squared distances to avoid square roots
+		return r.kNearestNeighbors(k, maxDist*maxDist, query.Filter, sqDistanceTo(query.Pos))
+	}
+	return r.kNearestNeighbors(k, maxDist, query.Filter, func(bounds vmath.Rectf) float32 {
+		return query.Metric(query.Pos, bounds)
+	})
+}
+
+// KNearestNeighbors returns the k items that are closest to the given position.
+// The items are sorted by their distance, starting with the closest one.
+// Returns less than k items if the tree does not contain enough items.
+func (r *RTree) KNearestNeighbors(pos vmath.Vec2f, k int) []Item {
+	r.flush()
+	return r.kNearestNeighbors(k, math32.Infinity, nil, sqDistanceTo(pos))
+}
+
+// SearchCircleSorted returns all items within the given radius around center.
+// The items are sorted by their distance, starting with the closest one.
+func (r *RTree) SearchCircleSorted(center vmath.Vec2f, radius float32) []Item {
+	r.flush()
+	if radius < 0 {
+		return nil
+	}
+	return r.kNearestNeighbors(maxInt, radius*radius, nil, sqDistanceTo(center))
+}
+
+// SearchAnnulus returns all items whose distance to center is within [innerRadius, outerRadius].
+// The distance of an item is the distance between center and the closest point of its bounds.
+// Items are returned in an undefined order. Panics if innerRadius is bigger than outerRadius.
+func (r *RTree) SearchAnnulus(center vmath.Vec2f, innerRadius, outerRadius float32) []Item {
+	if innerRadius > outerRadius {
+		panic("rtree: inner radius must not exceed outer radius")
+	}
+	r.flush()
+	if outerRadius < 0 {
+		return nil
+	}
+	sqInner, sqOuter := innerRadius*innerRadius, outerRadius*outerRadius
+	if innerRadius < 0 {
+		sqInner = 0
+	}
+
+	var items []Item
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
+			sqDist := item.Bounds().SquarePointDistance(center)
+			if sqDist >= sqInner && sqDist <= sqOuter {
+				items = append(items, item)
+			}
+		}
+		for _, child := range node.children {
+			if child.bounds.SquarePointDistance(center) > sqOuter {
+				continue
+			}
+			if maxSqDistance(center, child.bounds) < sqInner { // all items are within the inner radius
+				continue
+			}
+			nodesToSearch = append(nodesToSearch, child)
+		}
+	}
+	return items
+}
+
+// KNearestNeighborsBatch returns the k nearest neighbors for each of the given positions.
+// The result is aligned by index with 'points'. Each result is sorted by distance, like KNearestNeighbors.
+//
+// Queries are executed in parallel on the given number of workers.
+// If workers is <= 0, the number of CPUs is used.
+// The tree must not be modified until the function returns.
+func (r *RTree) KNearestNeighborsBatch(points []vmath.Vec2f, k int, workers int) [][]Item {
+	r.flush()
+	results := make([][]Item, len(points))
+	parallelize(len(points), workers, func(i int) {
+		results[i] = r.KNearestNeighbors(points[i], k)
+	})
+	return results
+}
+
+// AnyWithin returns true if there is at least one item within the given radius around the position.
+// The search stops at the first found item.
+func (r *RTree) AnyWithin(pos vmath.Vec2f, radius float32) bool {
+	r.flush()
+	return r.anyWithin(pos, radius*radius)
+}
+
+// AnyWithinBatch checks for every position if there is at least one item within the given radius, like AnyWithin.
+// The result is aligned by index with 'points'.
+//
+// Queries are executed in parallel on the given number of workers.
+// If workers is <= 0, the number of CPUs is used.
+// The tree must not be modified until the function returns.
+func (r *RTree) AnyWithinBatch(points []vmath.Vec2f, radius float32, workers int) []bool {
+	r.flush()
+	sqRadius := radius * radius
+	results := make([]bool, len(points))
+	parallelize(len(points), workers, func(i int) {
+		results[i] = r.anyWithin(points[i], sqRadius)
+	})
+	return results
+}
+
+func (r *RTree) anyWithin(pos vmath.Vec2f, sqRadius float32) bool {
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, item := range node.items {
+			if !isTombstone(item) && item.Bounds().SquarePointDistance(pos) <= sqRadius {
+				return true
+			}
+		}
+		for _, child := range node.children {
+			if child.bounds.SquarePointDistance(pos) <= sqRadius {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+	}
+	return false
+}
+
+// ForEachNearest calls the provided function for every stored item until true (=abort) is returned.
+// Items are iterated by their distance to the given position, starting with the closest one.
+// 'sqDist' is the squared distance between the position and the item.
+// Items are searched incrementally, so that stopping early avoids searching the remaining tree.
+func (r *RTree) ForEachNearest(pos vmath.Vec2f, fn func(item Item, sqDist float32) bool) {
+	r.flush()
+	queue := entryQueue{{node: r.root.Load()}}
+	for len(queue) > 0 {
+		entry := heap.Pop(&queue).(queueEntry)
+		if entry.item != nil {
+			if fn(entry.item, entry.dist) {
+				return
+			}
+			continue
+		}
+
+		for _, item := range entry.node.items {
+			if !isTombstone(item) {
+				heap.Push(&queue, queueEntry{item: item, dist: item.Bounds().SquarePointDistance(pos)})
+			}
+		}
+		for _, child := range entry.node.children {
+			heap.Push(&queue, queueEntry{node: child, dist: child.bounds.SquarePointDistance(pos)})
+		}
+	}
+}
+
+// NearestInArea returns the item closest to the given position, among all items that intersect the area.
+// 'filter' is optional. If 'filter' returns false, the item is discarded.
+// Returns nil if there is no such item.
+func (r *RTree) NearestInArea(pos vmath.Vec2f, area vmath.Rectf, filter FilterFunc) Item {
+	r.flush()
+	area = area.Normalize()
+
+	queue := entryQueue{{node: r.root.Load()}}
+	for len(queue) > 0 {
+		entry := heap.Pop(&queue).(queueEntry)
+		if entry.item != nil {
+			return entry.item
+		}
+
+		for _, item := range entry.node.items {
+			if isTombstone(item) || !area.Intersects(item.Bounds()) {
+				continue
+			}
+			if filter == nil || filter(item) {
+				heap.Push(&queue, queueEntry{item: item, dist: item.Bounds().SquarePointDistance(pos)})
+			}
+		}
+		for _, child := range entry.node.children {
+			if area.Intersects(child.bounds) {
+				heap.Push(&queue, queueEntry{node: child, dist: child.bounds.SquarePointDistance(pos)})
+			}
+		}
+	}
+	return nil
+}
+
+// NearestNeighborExcludingArea returns the item closest to the given position,
+// ignoring all items that are fully within the excluded area.
+// Returns nil if there is no such item.
+func (r *RTree) NearestNeighborExcludingArea(pos vmath.Vec2f, exclude vmath.Rectf) Item {
+	r.flush()
+	exclude = exclude.Normalize()
+
+	queue := entryQueue{{node: r.root.Load()}}
+	for len(queue) > 0 {
+		entry := heap.Pop(&queue).(queueEntry)
+		if entry.item != nil {
+			return entry.item
+		}
+
+		for _, item := range entry.node.items {
+			if !isTombstone(item) && !exclude.ContainsRectf(item.Bounds()) {
+				heap.Push(&queue, queueEntry{item: item, dist: item.Bounds().SquarePointDistance(pos)})
+			}
+		}
+		for _, child := range entry.node.children {
+			if !exclude.ContainsRectf(child.bounds) { // otherwise, all items are excluded
+				heap.Push(&queue, queueEntry{node: child, dist: child.bounds.SquarePointDistance(pos)})
+			}
+		}
+	}
+	return nil
+}
+
+// NearestNeighborWeighted returns the item with the smallest weighted distance to the given position.
+// The weighted distance of an item is its squared distance to the position, multiplied by weight(item).
+// 'minWeight' must be a lower bound for all weights and is used to skip distant nodes.
+// With a minWeight of 0, all nodes need to be searched.
+// Weights must not be negative. Returns nil if the tree is empty.
+func (r *RTree) NearestNeighborWeighted(pos vmath.Vec2f, weight func(item Item) float32, minWeight float32) Item {
+	r.flush()
+
+	queue := entryQueue{{node: r.root.Load()}}
+	for len(queue) > 0 {
+		entry := heap.Pop(&queue).(queueEntry)
+		if entry.item != nil {
+			return entry.item
+		}
+
+		for _, item := range entry.node.items {
+			if !isTombstone(item) {
+				sqDist := item.Bounds().SquarePointDistance(pos)
+				heap.Push(&queue, queueEntry{item: item, dist: sqDist * weight(item)})
+			}
+		}
+		for _, child := range entry.node.children {
+			sqDist := child.bounds.SquarePointDistance(pos)
+			heap.Push(&queue, queueEntry{node: child, dist: sqDist * minWeight})
+		}
+	}
+	return nil
+}
+
+// MostIsolatedItem returns the item with the largest distance to its nearest neighbor, together with that distance.
+// The distance between two items is the shortest distance between their bounding boxes.
+// A nearest-neighbor search is performed for every item, so the costs are O(n log n).
+// Returns false if the tree contains less than two items.
+func (r *RTree) MostIsolatedItem() (Item, float32, bool) {
+	r.flush()
+	root := r.root.Load()
+	var best Item
+	bestSqDist := float32(-1)
+
+	iterateAllItems(root, func(item Item) bool {
+		if _, sqDist, ok := nearestOther(root, item, math32.Infinity); ok && sqDist > bestSqDist {
+			best, bestSqDist = item, sqDist
+		}
+		return false
+	})
+	if best == nil {
+		return nil, 0, false
+	}
+	return best, math32.Sqrt(bestSqDist), true
+}
+
+// nearestOther returns the item that is closest to the given item, and their squared distance.
+// Only items closer than maxSqDist are considered. Returns false if there is no such item.
+func nearestOther(root *node, item Item, maxSqDist float32) (Item, float32, bool) {
+	bounds := item.Bounds()
+	skipped := false // the item itself is skipped once; identical duplicates are treated as other items
+
+	queue := entryQueue{{node: root}}
+	for len(queue) > 0 {
+		entry := heap.Pop(&queue).(queueEntry)
+		if entry.dist >= maxSqDist {
+			break
+		}
+		if entry.item != nil {
+			return entry.item, entry.dist, true
+		}
+
+		for _, other := range entry.node.items {
+			if isTombstone(other) {
+				continue
+			}
+			if !skipped && other == item {
+				skipped = true
+				continue
+			}
+			heap.Push(&queue, queueEntry{item: other, dist: rectSqDistance(bounds, other.Bounds())})
+		}
+		for _, child := range entry.node.children {
+			heap.Push(&queue, queueEntry{node: child, dist: rectSqDistance(bounds, child.bounds)})
+		}
+	}
+	return nil, 0, false
+}
+
+// rectSqDistance returns the squared distance between the closest points of the two boxes, or 0 if they intersect.
+func rectSqDistance(a, b vmath.Rectf) float32 {
+	dx := math32.Max(0, math32.Max(a.Min[0]-b.Max[0], b.Min[0]-a.Max[0]))
+	dy := math32.Max(0, math32.Max(a.Min[1]-b.Max[1], b.Min[1]-a.Max[1]))
+	return dx*dx + dy*dy
+}
+
+// ClosestPair returns the two items with the smallest distance between each other, and their squared distance.
+// The distance between two items is the shortest distance between their bounding boxes.
+// For every item, a nearest-neighbor search is performed, which skips subtrees that are farther away
+// than the closest pair found so far.
+// Returns false if the tree contains less than two items.
+func (r *RTree) ClosestPair() (a, b Item, sqDist float32, ok bool) {
+	r.flush()
+	root := r.root.Load()
+	sqDist = math32.Infinity
+
+	iterateAllItems(root, func(item Item) bool {
+		if other, d, found := nearestOther(root, item, sqDist); found {
+			a, b, sqDist, ok = item, other, d, true
+		}
+		return ok && sqDist == 0 // can't get any closer
+	})
+	if !ok {
+		return nil, nil, 0, false
+	}
+	return a, b, sqDist, true
+}
+
+// NearestNeighborAxis returns the item that is closest to the given position along a single axis.
+// 'axis' is 0 for the x-axis and 1 for the y-axis. The distance along the other axis is ignored.
+// Returns nil if the tree is empty.
+func (r *RTree) NearestNeighborAxis(pos vmath.Vec2f, axis int) Item {
+	if axis != 0 && axis != 1 {
+		panic("rtree: axis must be 0 or 1")
+	}
+	r.flush()
+	items := r.kNearestNeighbors(1, math32.Infinity, nil, func(bounds vmath.Rectf) float32 {
+		return axisDistances(pos, bounds)[axis]
+	})
+	if len(items) == 0 {
+		return nil
+	}
+	return items[0]
+}
+
+// NearestNeighborsTied returns all items that share the smallest distance to the given position.
+// Returns nil if the tree is empty.
+func (r *RTree) NearestNeighborsTied(pos vmath.Vec2f) []Item {
+	r.flush()
+	var items []Item
+	minDist := math32.Infinity
+
+	queue := entryQueue{{node: r.root.Load()}}
+	for len(queue) > 0 {
+		entry := heap.Pop(&queue).(queueEntry)
+		if items != nil && entry.dist > minDist {
+			break // all remaining entries are farther away
+		}
+
+		if entry.item != nil {
+			minDist = entry.dist
+			items = append(items, entry.item)
+			continue
+		}
+
+		for _, item := range entry.node.items {
+			if !isTombstone(item) {
+				heap.Push(&queue, queueEntry{item: item, dist: item.Bounds().SquarePointDistance(pos)})
+			}
+		}
+		for _, child := range entry.node.children {
+			heap.Push(&queue, queueEntry{node: child, dist: child.bounds.SquarePointDistance(pos)})
+		}
+	}
+	return items
+}
+
+// ClusterInfo describes a tree node, which represents a cluster of nearby items.
+type ClusterInfo struct {
+	Bounds vmath.Rectf // bounding box of all items within the cluster
+	Height int         // height of the node; leaf nodes have a height of 1
+	Count  int         // number of items within the cluster
+}
+
+// NearestClusters returns the k tree nodes closest to the given position, descending no deeper than maxHeight.
+// The clusters are sorted by their distance, starting with the closest one.
+// Nodes without items are skipped.
+func (r *RTree) NearestClusters(pos vmath.Vec2f, k int, maxHeight int) []ClusterInfo {
+	r.flush()
+	if k <= 0 {
+		return nil
+	}
+	var clusters []ClusterInfo
+
+	queue := entryQueue{{node: r.root.Load()}}
+	for len(queue) > 0 {
+		nod := heap.Pop(&queue).(queueEntry).node
+
+		if nod.height <= maxHeight || nod.leaf {
+			if cnt := countSubtreeItems(nod, nil); cnt > 0 {
+				clusters = append(clusters, ClusterInfo{
+					Bounds: nod.bounds,
+					Height: nod.height,
+					Count:  cnt,
+				})
+				if len(clusters) == k {
+					break
+				}
+			}
+			continue
+		}
+		for _, child := range nod.children {
+			heap.Push(&queue, queueEntry{node: child, dist: child.bounds.SquarePointDistance(pos)})
+		}
+	}
+	return clusters
+}
+
+// KFarthestNeighbors returns the k items that are farthest away from the given position.
+// The items are sorted by their distance, starting with the farthest one.
+// Returns less than k items if the tree does not contain enough items.
+func (r *RTree) KFarthestNeighbors(pos vmath.Vec2f, k int) []Item {
+	r.flush()
+	if k <= 0 {
+		return nil
+	}
+
+	farthest := make(entryQueue, 0, k) // min-heap; the first entry is the closest one
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, child := range node.children {
+			// prune nodes where all items are closer than the current k farthest ones
+			if len(farthest) < k || maxSqDistance(pos, child.bounds) > farthest[0].dist {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
+			dist := item.Bounds().SquarePointDistance(pos)
+			if len(farthest) < k {
+				heap.Push(&farthest, queueEntry{item: item, dist: dist})
+			} else if dist > farthest[0].dist {
+				farthest[0] = queueEntry{item: item, dist: dist}
+				heap.Fix(&farthest, 0)
+			}
+		}
+	}
+
+	items := make([]Item, len(farthest))
+	for i := len(items) - 1; i >= 0; i-- {
+		items[i] = heap.Pop(&farthest).(queueEntry).item
+	}
+	return items
+}
+
+// maxSqDistance returns the squared distance between pos and the farthest point within the bounds.
+func maxSqDistance(pos vmath.Vec2f, bounds vmath.Rectf) float32 {
+	sum := float32(0)
+	for dim, val := range pos {
+		d := math32.Max(math32.Abs(val-bounds.Min[dim]), math32.Abs(val-bounds.Max[dim]))
+		sum += d * d
+	}
+	return sum
+}
+
+// kNearestNeighbors performs a best-first search for the k closest items within the given max. distance.
+// 'filter' is optional. 'dist' calculates the distance of bounding boxes to the query position.
+func (r *RTree) kNearestNeighbors(k int, maxDist float32, filter FilterFunc, dist func(bounds vmath.Rectf) float32) []Item {
+	if k <= 0 {
+		return nil
+	}
+	var items []Item
+
+	queue := entryQueue{{node: r.root.Load()}}
+	for len(queue) > 0 {
+		entry := heap.Pop(&queue).(queueEntry)
+		if entry.dist > maxDist {
+			break
+		}
+
+		if entry.item != nil {
+			items = append(items, entry.item)
+			if len(items) == k {
+				break
+			}
+			continue
+		}
+
+		for _, item := range entry.node.items {
+			if isTombstone(item) {
+				continue
+			}
+			if filter == nil || filter(item) {
+				heap.Push(&queue, queueEntry{item: item, dist: dist(item.Bounds())})
+			}
+		}
+		for _, child := range entry.node.children {
+			heap.Push(&queue, queueEntry{node: child, dist: dist(child.bounds)})
+		}
+	}
+	return items
+}
+
+// sqDistanceTo returns a function calculating the squared euclidean distance of bounding boxes to pos.
+func sqDistanceTo(pos vmath.Vec2f) func(bounds vmath.Rectf) float32 {
+	return func(bounds vmath.Rectf) float32 {
+		return bounds.SquarePointDistance(pos)
+	}
+}
+
+// parallelize calls fn for every index in [0, count) using the given number of goroutines.
+// If workers is <= 0, the number of CPUs is used.
+func parallelize(count int, workers int, fn func(i int)) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < count; i += workers {
+				fn(i)
+			}
+		}(w)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,424 @@
+package rtree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/maja42/vmath"
+	"github.com/maja42/vmath/math32"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKNearestNeighborsBatch(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	points := []vmath.Vec2f{{0, 0}, {50, 50}, {100, 20}, {-30, 130}}
+	k := 20
+
+	results := tree.KNearestNeighborsBatch(points, k, 3)
+	assert.Len(t, results, len(points))
+	for i, pos := range points {
+		assert.Equal(t, bruteForceSqDistances(items, pos)[:k], sqDistances(results[i], pos))
+	}
+
+	assert.Len(t, tree.KNearestNeighbors(points[0], 5000), 1000)
+	assert.Nil(t, New().KNearestNeighbors(points[0], 3))
+}
+
+func TestAnyWithinBatch(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	points := []vmath.Vec2f{{0, 0}, {50, 50}, {100, 20}, {-30, 130}, {500, 500}}
+	radius := float32(2)
+
+	results := tree.AnyWithinBatch(points, radius, 2)
+	assert.Len(t, results, len(points))
+	for i, pos := range points {
+		expected := bruteForceSqDistances(items, pos)[0] <= radius*radius
+		assert.Equal(t, expected, results[i])
+		assert.Equal(t, expected, tree.AnyWithin(pos, radius))
+	}
+	assert.True(t, results[1])
+	assert.False(t, results[4])
+
+	assert.Equal(t, []bool{false}, New().AnyWithinBatch(points[:1], radius, 0))
+}
+
+// sqDistances returns the squared distances of all items to pos.
+func sqDistances(items []Item, pos vmath.Vec2f) []float32 {
+	dists := make([]float32, len(items))
+	for i, item := range items {
+		dists[i] = item.Bounds().SquarePointDistance(pos)
+	}
+	return dists
+}
+
+// bruteForceSqDistances returns the sorted squared distances of all items to pos.
+func bruteForceSqDistances(items []Item, pos vmath.Vec2f) []float32 {
+	dists := sqDistances(items, pos)
+	sort.Slice(dists, func(i, j int) bool { return dists[i] < dists[j] })
+	return dists
+}
+
+func TestNearestNeighbors(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{30, 70}
+	filter := func(item Item) bool {
+		return item.Bounds().Min[0] < 50
+	}
+
+	var expected []Item
+	for _, item := range items {
+		if filter(item) && item.Bounds().PointDistance(pos) <= 10 {
+			expected = append(expected, item)
+		}
+	}
+
+	result := tree.NearestNeighbors(NNQuery{
+		Pos:         pos,
+		MaxDistance: 10,
+		Filter:      filter,
+	})
+	assert.ElementsMatch(t, expected, result)
+	assert.True(t, sort.SliceIsSorted(result, func(i, j int) bool {
+		return result[i].Bounds().SquarePointDistance(pos) < result[j].Bounds().SquarePointDistance(pos)
+	}))
+
+	result = tree.NearestNeighbors(NNQuery{
+		Pos:    pos,
+		K:      5,
+		Metric: EuclideanDistance,
+	})
+	assert.Equal(t, bruteForceSqDistances(items, pos)[:5], sqDistances(result, pos))
+}
+
+func TestSortNodesByDistance(t *testing.T) {
+	// Regression test: Swap must move the distances together with the nodes.
+	pos := vmath.Vec2f{0, 0}
+	var nodes []*node
+	for i := 10; i > 0; i-- {
+		nod := newNode()
+		nod.bounds = vmath.Rectf{Min: vmath.Vec2f{float32(i), 0}, Max: vmath.Vec2f{float32(i) + 1, 1}}
+		nodes = append(nodes, nod)
+	}
+
+	sorted := sortNodesByDistance(pos, nodes)
+	assert.True(t, sort.SliceIsSorted(sorted.sqDistances, func(i, j int) bool {
+		return sorted.sqDistances[i] < sorted.sqDistances[j]
+	}))
+	for i, nod := range sorted.nodes {
+		assert.Equal(t, nod.bounds.SquarePointDistance(pos), sorted.sqDistances[i])
+	}
+	assert.Equal(t, float32(10), nodes[0].bounds.Min[0], "input slice must not be reordered")
+}
+
+func TestKFarthestNeighbors(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{30, 60}
+
+	expected := bruteForceSqDistances(items, pos)
+	for i, j := 0, len(expected)-1; i < j; i, j = i+1, j-1 {
+		expected[i], expected[j] = expected[j], expected[i]
+	}
+
+	assert.Equal(t, expected[:25], sqDistances(tree.KFarthestNeighbors(pos, 25), pos))
+	assert.Equal(t, expected, sqDistances(tree.KFarthestNeighbors(pos, 2000), pos))
+	assert.Empty(t, New().KFarthestNeighbors(pos, 3))
+}
+
+func TestSearchCircleSorted(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{30, 70}
+	radius := float32(10)
+
+	var expected []float32
+	for _, dist := range bruteForceSqDistances(items, pos) {
+		if dist <= radius*radius {
+			expected = append(expected, dist)
+		}
+	}
+	found := tree.SearchCircleSorted(pos, radius)
+	assert.Equal(t, expected, sqDistances(found, pos))
+
+	assert.Empty(t, tree.SearchCircleSorted(pos, -1))
+}
+
+func TestNearestNeighborsTied(t *testing.T) {
+	tree := New()
+	var tied []Item
+	for i := 0; i < 100; i++ {
+		x, y := float32(i%10), float32(i/10)
+		item := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{x, y}, Max: vmath.Vec2f{x, y}}}
+		tree.Insert(item)
+		if (x == 4 || x == 5) && (y == 4 || y == 5) {
+			tied = append(tied, item)
+		}
+	}
+	assert.ElementsMatch(t, tied, tree.NearestNeighborsTied(vmath.Vec2f{4.5, 4.5}))
+	assert.Len(t, tree.NearestNeighborsTied(vmath.Vec2f{3, 3}), 1)
+
+	assert.Nil(t, New().NearestNeighborsTied(vmath.Vec2f{}))
+}
+
+func TestNearestClusters(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{30, 70}
+
+	clusters := tree.NearestClusters(pos, 3, 2)
+	assert.Len(t, clusters, 3)
+	for i, cluster := range clusters {
+		assert.Equal(t, 2, cluster.Height)
+		assert.GreaterOrEqual(t, len(tree.Search(cluster.Bounds, true)), cluster.Count)
+		if i > 0 {
+			assert.LessOrEqual(t, clusters[i-1].Bounds.SquarePointDistance(pos), cluster.Bounds.SquarePointDistance(pos))
+		}
+	}
+
+	all := tree.NearestClusters(pos, 1, tree.Height())
+	assert.Equal(t, []ClusterInfo{{Bounds: tree.Bounds(), Height: tree.Height(), Count: len(items)}}, all)
+
+	total := 0
+	for _, cluster := range tree.NearestClusters(pos, maxInt, 1) {
+		total += cluster.Count
+	}
+	assert.Equal(t, len(items), total)
+
+	assert.Empty(t, New().NearestClusters(pos, 5, 1))
+}
+
+func TestNearestInArea(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{30, 70}
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{50, 10},
+		Max: vmath.Vec2f{60, 40},
+	}
+	filter := func(item Item) bool {
+		return item.Bounds().Area() < 500
+	}
+
+	var candidates []Item
+	for _, item := range items {
+		if area.Intersects(item.Bounds()) && filter(item) {
+			candidates = append(candidates, item)
+		}
+	}
+	nearest := tree.NearestInArea(pos, area, filter)
+	assert.True(t, area.Intersects(nearest.Bounds()))
+	assert.True(t, filter(nearest))
+	assert.Equal(t, bruteForceSqDistances(candidates, pos)[0], nearest.Bounds().SquarePointDistance(pos))
+
+	assert.Nil(t, tree.NearestInArea(pos, vmath.Rectf{Min: vmath.Vec2f{200, 200}, Max: vmath.Vec2f{300, 300}}, nil))
+}
+
+func TestForEachNearest(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{30, 70}
+
+	var dists []float32
+	tree.ForEachNearest(pos, func(item Item, sqDist float32) bool {
+		assert.Equal(t, item.Bounds().SquarePointDistance(pos), sqDist)
+		dists = append(dists, sqDist)
+		return false
+	})
+	assert.Equal(t, bruteForceSqDistances(items, pos), dists)
+
+	cnt := 0
+	tree.ForEachNearest(pos, func(item Item, sqDist float32) bool {
+		cnt++
+		return cnt == 10
+	})
+	assert.Equal(t, 10, cnt)
+}
+
+func TestNearestNeighborAxis(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{30, 170}
+
+	for axis := 0; axis <= 1; axis++ {
+		expected := math32.Infinity
+		for _, item := range items {
+			expected = math32.Min(expected, axisDistances(pos, item.Bounds())[axis])
+		}
+		nearest := tree.NearestNeighborAxis(pos, axis)
+		assert.Equal(t, expected, axisDistances(pos, nearest.Bounds())[axis])
+	}
+
+	assert.Nil(t, New().NearestNeighborAxis(pos, 0))
+	assert.Panics(t, func() { tree.NearestNeighborAxis(pos, 2) })
+}
+
+func TestNearestNeighborExcludingArea(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{30, 30}
+	exclude := vmath.Rectf{
+		Min: vmath.Vec2f{20, 20},
+		Max: vmath.Vec2f{40, 40},
+	}
+
+	var candidates []Item
+	for _, item := range items {
+		if !exclude.ContainsRectf(item.Bounds()) {
+			candidates = append(candidates, item)
+		}
+	}
+	nearest := tree.NearestNeighborExcludingArea(pos, exclude)
+	assert.False(t, exclude.ContainsRectf(nearest.Bounds()))
+	assert.Equal(t, bruteForceSqDistances(candidates, pos)[0], nearest.Bounds().SquarePointDistance(pos))
+
+	assert.Nil(t, tree.NearestNeighborExcludingArea(pos, tree.Bounds()))
+}
+
+func TestNearestNeighborCovering(t *testing.T) {
+	tree := New()
+	a := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{10, 10}}}
+	b := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{20, 0}, Max: vmath.Vec2f{30, 10}}}
+	tree.Insert(a).Insert(b)
+
+	item, covering := tree.NearestNeighborCovering(vmath.Vec2f{5, 5})
+	assert.Same(t, a, item)
+	assert.True(t, covering)
+
+	item, covering = tree.NearestNeighborCovering(vmath.Vec2f{18, 5})
+	assert.Same(t, b, item)
+	assert.False(t, covering)
+
+	item, covering = New().NearestNeighborCovering(vmath.Vec2f{5, 5})
+	assert.Nil(t, item)
+	assert.False(t, covering)
+}
+
+func TestNearestNeighborWeighted(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{50, 50}
+	weight := func(item Item) float32 {
+		return 1 + float32(len(item.(*testItem).data)%4)
+	}
+	for i, item := range items {
+		item.(*testItem).data = make([]byte, i%7)
+	}
+
+	var expected float32 = math32.Infinity
+	for _, item := range items {
+		expected = math32.Min(expected, item.Bounds().SquarePointDistance(pos)*weight(item))
+	}
+	for _, minWeight := range []float32{0, 0.5, 1} {
+		nearest := tree.NearestNeighborWeighted(pos, weight, minWeight)
+		assert.Equal(t, expected, nearest.Bounds().SquarePointDistance(pos)*weight(nearest))
+	}
+
+	unweighted := tree.NearestNeighborWeighted(pos, func(Item) float32 { return 1 }, 1)
+	assert.Equal(t, tree.NearestNeighbor(pos).Bounds().SquarePointDistance(pos), unweighted.Bounds().SquarePointDistance(pos))
+
+	assert.Nil(t, New().NearestNeighborWeighted(pos, weight, 1))
+}
+
+func TestMostIsolatedItem(t *testing.T) {
+	tree, items := newPrePopulatedTree(300)
+
+	var expected float32
+	for _, item := range items {
+		nearest := math32.Infinity
+		for _, other := range items {
+			if other != item {
+				nearest = math32.Min(nearest, rectSqDistance(item.Bounds(), other.Bounds()))
+			}
+		}
+		expected = math32.Max(expected, nearest)
+	}
+	item, dist, ok := tree.MostIsolatedItem()
+	assert.True(t, ok)
+	assert.NotNil(t, item)
+	assert.InDelta(t, math32.Sqrt(expected), dist, 1e-4)
+
+	isolated := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{300, 300}, Max: vmath.Vec2f{301, 301}}}
+	tree.Insert(isolated)
+	item, _, _ = tree.MostIsolatedItem()
+	assert.Same(t, isolated, item)
+
+	_, _, ok = New().Insert(isolated).MostIsolatedItem()
+	assert.False(t, ok)
+}
+
+func TestNearestNeighborPoint(t *testing.T) {
+	tree := New()
+	a := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{10, 10}}}
+	b := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{20, 0}, Max: vmath.Vec2f{30, 10}}}
+	tree.Insert(a).Insert(b)
+
+	item, point, ok := tree.NearestNeighborPoint(vmath.Vec2f{17, 15})
+	assert.True(t, ok)
+	assert.Same(t, b, item)
+	assert.Equal(t, vmath.Vec2f{20, 10}, point)
+
+	item, point, ok = tree.NearestNeighborPoint(vmath.Vec2f{5, 5})
+	assert.True(t, ok)
+	assert.Same(t, a, item)
+	assert.Equal(t, vmath.Vec2f{5, 5}, point)
+
+	_, _, ok = New().NearestNeighborPoint(vmath.Vec2f{5, 5})
+	assert.False(t, ok)
+}
+
+func TestSearchAnnulus(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	center := vmath.Vec2f{50, 50}
+	inner, outer := float32(10), float32(20)
+
+	var expected []Item
+	for _, item := range items {
+		sqDist := item.Bounds().SquarePointDistance(center)
+		if sqDist >= inner*inner && sqDist <= outer*outer {
+			expected = append(expected, item)
+		}
+	}
+	assert.NotEmpty(t, expected)
+	assert.ElementsMatch(t, expected, tree.SearchAnnulus(center, inner, outer))
+
+	assert.ElementsMatch(t, tree.SearchCircleSorted(center, outer), tree.SearchAnnulus(center, 0, outer))
+	assert.PanicsWithValue(t, "rtree: inner radius must not exceed outer radius", func() {
+		tree.SearchAnnulus(center, outer, inner)
+	})
+}
+
+func TestPopNearest(t *testing.T) {
+	tree, items := newPrePopulatedTree(100)
+	pos := vmath.Vec2f{50, 50}
+	expected := bruteForceSqDistances(items, pos)
+
+	for _, sqDist := range expected {
+		item, ok := tree.PopNearest(pos, nil)
+		assert.True(t, ok)
+		assert.Equal(t, sqDist, item.Bounds().SquarePointDistance(pos))
+	}
+	assert.Equal(t, 0, tree.Size())
+
+	item, ok := tree.PopNearest(pos, nil)
+	assert.Nil(t, item)
+	assert.False(t, ok)
+}
+
+func TestClosestPair(t *testing.T) {
+	for run := 0; run < 5; run++ {
+		items := make([]Item, 200)
+		for i := range items {
+			pos := vmath.Vec2f{rand.Float32() * 1000, rand.Float32() * 1000}
+			items[i] = &testItem{bounds: vmath.Rectf{Min: pos, Max: pos.Add(vmath.Vec2f{1, 1})}}
+		}
+		tree := New().BulkLoad(items)
+
+		expected := math32.Infinity
+		for i, a := range items {
+			for _, b := range items[i+1:] {
+				expected = math32.Min(expected, rectSqDistance(a.Bounds(), b.Bounds()))
+			}
+		}
+		a, b, sqDist, ok := tree.ClosestPair()
+		assert.True(t, ok)
+		assert.NotSame(t, a, b)
+		assert.Equal(t, expected, sqDist)
+		assert.Equal(t, expected, rectSqDistance(a.Bounds(), b.Bounds()))
+	}
+
+	_, _, _, ok := New().Insert(randomItem()).ClosestPair()
+	assert.False(t, ok)
+}
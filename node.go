@@ -1,6 +1,9 @@
 package rtree
 
 import (
+	"slices"
+	"sort"
+
 	"github.com/maja42/vmath"
 	"github.com/maja42/vmath/math32"
 )
@@ -58,11 +61,48 @@ func (a itemsByMinY) Len() int           { return len(a) }
 func (a itemsByMinY) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a itemsByMinY) Less(i, j int) bool { return a[i].Bounds().Min[1] < a[j].Bounds().Min[1] }
 
+// insertSortedX inserts the item into a slice that is sorted by min. x-coordinate, keeping it sorted.
+// Items with equal coordinates keep their insertion order.
+func insertSortedX(items []Item, item Item, minX float32) []Item {
+	idx := sort.Search(len(items), func(i int) bool {
+		return items[i].Bounds().Min[0] > minX
+	})
+	return slices.Insert(items, idx, item)
+}
+
 type nodesByDistance struct {
 	nodes       []*node
 	sqDistances []float32
 }
 
-func (a nodesByDistance) Len() int           { return len(a.nodes) }
-func (a nodesByDistance) Swap(i, j int)      { a.nodes[i], a.nodes[j] = a.nodes[j], a.nodes[i] }
+func (a nodesByDistance) Len() int { return len(a.nodes) }
+func (a nodesByDistance) Swap(i, j int) {
+	a.nodes[i], a.nodes[j] = a.nodes[j], a.nodes[i]
+	a.sqDistances[i], a.sqDistances[j] = a.sqDistances[j], a.sqDistances[i]
+}
 func (a nodesByDistance) Less(i, j int) bool { return a.sqDistances[i] < a.sqDistances[j] }
+
+// queueEntry is either a node or an item, together with its distance to a query position.
+type queueEntry struct {
+	node *node
+	item Item
+	dist float32 // distance to the query position; usually squared
+}
+
+// entryQueue is a priority queue returning the closest entries first.
+// It implements heap.Interface.
+type entryQueue []queueEntry
+
+func (q entryQueue) Len() int           { return len(q) }
+func (q entryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q entryQueue) Less(i, j int) bool { return q[i].dist < q[j].dist }
+
+func (q *entryQueue) Push(x interface{}) { *q = append(*q, x.(queueEntry)) }
+
+func (q *entryQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	old[len(old)-1] = queueEntry{} // allow garbage collection
+	*q = old[:len(old)-1]
+	return entry
+}
@@ -1,11 +1,13 @@
 package rtree
 
 import (
+	"container/heap"
 	"math"
 	"sort"
 
 	"github.com/maja42/vmath"
 	"github.com/maja42/vmath/math32"
+	"github.com/maja42/vmath/mathi"
 )
 
 const maxInt = math.MaxInt32
@@ -13,26 +15,78 @@ const maxInt = math.MaxInt32
 // All returns all stored items.
 // Returns nil if the tree is empty.
 func (r *RTree) All() []Item {
+	r.flush()
 	var items []Item
-	r.addAllItemsN(r.root, &items, maxInt)
+	r.addAllItemsN(r.root.Load(), &items, maxInt)
 	return items
 }
 
 // SearchPos returns all items at the given position.
+// Items are also returned if the position lies exactly on their edge.
 func (r *RTree) SearchPos(pos vmath.Vec2f) []Item {
-	return r.search(vmath.Rectf{pos, pos}, false, maxInt)
+	r.flush()
+	return r.search(vmath.Rectf{Min: pos, Max: pos}, false, maxInt)
+}
+
+// SearchPosInclusive returns all items whose bounds contain the given position, including their edges (Min <= pos <= Max).
+// Positions on a shared edge between adjacent items therefore return all of these items.
+// This is the same as SearchPos, but guarantees the edge semantics independently of the search area's semantics.
+func (r *RTree) SearchPosInclusive(pos vmath.Vec2f) []Item {
+	r.flush()
+	var items []Item
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, child := range node.children {
+			if child.bounds.ContainsPoint(pos) {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+		for _, item := range node.items {
+			if !isTombstone(item) && item.Bounds().ContainsPoint(pos) {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
 }
 
 // SearchPos returns all items at the given position.
 // Stops searching after 'maxResults' have found.
 func (r *RTree) SearchPosN(pos vmath.Vec2f, maxResults int) []Item {
-	return r.search(vmath.Rectf{pos, pos}, false, maxResults)
+	r.flush()
+	return r.search(vmath.Rectf{Min: pos, Max: pos}, false, maxResults)
+}
+
+// SearchPosOrNearest returns an item at the given position.
+// If there is none, the item closest to the position is returned instead.
+// Returns nil if the tree is empty.
+func (r *RTree) SearchPosOrNearest(pos vmath.Vec2f) Item {
+	r.flush()
+	if items := r.search(vmath.Rectf{Min: pos, Max: pos}, false, 1); len(items) > 0 {
+		return items[0]
+	}
+	item, _ := r.nearestNeighbor(pos, r.root.Load(), nil, math32.Infinity)
+	return item
+}
+
+// SearchExactPos returns all point items that are located exactly at the given position.
+// These are items with bounds where Min == Max == pos.
+// Coordinates are compared using float equality without any tolerance (with -0 being equal to +0).
+func (r *RTree) SearchExactPos(pos vmath.Vec2f) []Item {
+	r.flush()
+	// only zero-area items at pos are fully covered by a zero-area search area
+	return r.search(vmath.Rectf{Min: pos, Max: pos}, true, maxInt)
 }
 
 // Search returns all items within the area.
 // If mustCover is true, items are only returned if they are fully within the search area.
 // If false, items are returned if they intersect the search area.
+// Edges are inclusive: items (including points) that lie exactly on the area's edge are returned in both cases.
 func (r *RTree) Search(area vmath.Rectf, mustCover bool) []Item {
+	r.flush()
 	return r.search(area, mustCover, maxInt)
 }
 
@@ -41,19 +95,607 @@ func (r *RTree) Search(area vmath.Rectf, mustCover bool) []Item {
 // If mustCover is true, items are only returned if they are fully within the search area.
 // If false, items are returned if they intersect the search area.
 func (r *RTree) SearchN(area vmath.Rectf, mustCover bool, maxResults int) []Item {
+	r.flush()
 	return r.search(area, mustCover, maxResults)
 }
 
-func (r *RTree) search(area vmath.Rectf, mustCover bool, maxResults int) []Item {
+// SearchTrace performs the same traversal as Search, but reports visited nodes instead of items.
+// The provided function is called for every node the search touches, starting with the root.
+// 'intersected' is true if the node intersects the search area and was therefore descended.
+// Nodes that are fully within the search area are descended completely.
+func (r *RTree) SearchTrace(area vmath.Rectf, fn func(bounds vmath.Rectf, height int, intersected bool)) {
+	r.flush()
+	area = area.Normalize()
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		intersected := area.Intersects(node.bounds)
+		fn(node.bounds, node.height, intersected)
+		if intersected {
+			nodesToSearch = append(nodesToSearch, node.children...)
+		}
+	}
+}
+
+// SearchHalfPlane returns all items that are entirely on one side of an axis-aligned line.
+// 'axis' is 0 for a vertical line at x=value, and 1 for a horizontal line at y=value.
+// If keepLower is true, items with Max[axis] <= value are returned. Otherwise, items with Min[axis] >= value are returned.
+// Items touching the line are therefore returned as well. Panics if the axis is not 0 or 1.
+func (r *RTree) SearchHalfPlane(axis int, value float32, keepLower bool) []Item {
+	if axis != 0 && axis != 1 {
+		panic("rtree: axis must be 0 or 1")
+	}
+	r.flush()
+	inside := func(bounds vmath.Rectf) bool {
+		if keepLower {
+			return bounds.Max[axis] <= value
+		}
+		return bounds.Min[axis] >= value
+	}
+	outside := func(bounds vmath.Rectf) bool {
+		if keepLower {
+			return bounds.Min[axis] > value
+		}
+		return bounds.Max[axis] < value
+	}
+
+	var items []Item
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, child := range node.children {
+			if outside(child.bounds) {
+				continue
+			}
+			if inside(child.bounds) {
+				r.addAllItemsN(child, &items, maxInt)
+			} else {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+		for _, item := range node.items {
+			if !isTombstone(item) && inside(item.Bounds()) {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// SearchGrouped returns all items within the area, grouped by the key returned by the given function.
+// If mustCover is true, items are only returned if they are fully within the search area.
+// If false, items are returned if they intersect the search area.
+func (r *RTree) SearchGrouped(area vmath.Rectf, mustCover bool, key func(item Item) string) map[string][]Item {
+	r.flush()
+	groups := make(map[string][]Item)
+	r.iterateSearch(area, mustCover, func(item Item) bool {
+		k := key(item)
+		groups[k] = append(groups[k], item)
+		return false
+	})
+	return groups
+}
+
+// SearchReduce folds all items within the area into the accumulator, by calling fn for every item.
+// This allows aggregating results (like counting items, or appending them to a reused buffer) without allocating a result slice.
+// If mustCover is true, items are only used if they are fully within the search area.
+// If false, items are used if they intersect the search area.
+// SearchReduce is a function instead of a method, since methods cannot have type parameters.
+func SearchReduce[R any](r *RTree, area vmath.Rectf, mustCover bool, acc R, fn func(acc R, item Item) R) R {
+	r.flush()
+	r.iterateSearch(area, mustCover, func(item Item) bool {
+		acc = fn(acc, item)
+		return false
+	})
+	return acc
+}
+
+// SearchBatch searches all given areas, like Search.
+// The result is aligned by index with 'areas'.
+//
+// Queries are executed in parallel on the given number of workers.
+// If workers is <= 0, the number of CPUs is used.
+// The tree must not be modified until the function returns.
+func (r *RTree) SearchBatch(areas []vmath.Rectf, mustCover bool, workers int) [][]Item {
+	r.flush()
+	results := make([][]Item, len(areas))
+	parallelize(len(areas), workers, func(i int) {
+		results[i] = r.search(areas[i], mustCover, maxInt)
+	})
+	return results
+}
+
+func (r *RTree) search(area vmath.Rectf, mustCover bool, maxResults int) []Item {
+	area = area.Normalize()
+	root := r.root.Load()
+	if !area.Intersects(root.bounds) {
+		return nil
+	}
+
+	var items []Item
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = root
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, child := range node.children {
+			if !area.Intersects(child.bounds) {
+				continue
+			}
+			if area.ContainsRectf(child.bounds) {
+				r.addAllItemsN(child, &items, maxResults)
+				if len(items) >= maxResults {
+					return items
+				}
+			} else {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
+			if (mustCover && area.ContainsRectf(item.Bounds())) ||
+				(!mustCover && area.Intersects(item.Bounds())) {
+				items = append(items, item)
+				if len(items) >= maxResults {
+					return items
+				}
+			}
+		}
+	}
+	return items
+}
+
+// QueryStats describes the work performed by a single query.
+type QueryStats struct {
+	NodesVisited int // Number of nodes whose children or items were inspected
+	ItemsTested  int // Number of items that were tested against the query area
+	Results      int // Number of returned items
+}
+
+// SearchStats returns all items within the area, like Search, together with statistics about the performed work.
+// Items of nodes that are fully within the search area are returned without being tested.
+func (r *RTree) SearchStats(area vmath.Rectf, mustCover bool) ([]Item, QueryStats) {
+	r.flush()
+	var stats QueryStats
+	area = area.Normalize()
+	root := r.root.Load()
+	if !area.Intersects(root.bounds) {
+		return nil, stats
+	}
+
+	var items []Item
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = root
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		stats.NodesVisited++
+
+		for _, child := range node.children {
+			if !area.Intersects(child.bounds) {
+				continue
+			}
+			if area.ContainsRectf(child.bounds) {
+				stats.NodesVisited += r.addAllItemsCounted(child, &items)
+			} else {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
+			stats.ItemsTested++
+			if (mustCover && area.ContainsRectf(item.Bounds())) ||
+				(!mustCover && area.Intersects(item.Bounds())) {
+				items = append(items, item)
+			}
+		}
+	}
+	stats.Results = len(items)
+	return items, stats
+}
+
+// addAllItemsCounted adds all items of the subtree and returns the number of visited nodes.
+func (r *RTree) addAllItemsCounted(root *node, items *[]Item) int {
+	visited := 0
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = root
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		visited++
+
+		for _, item := range node.items {
+			if !isTombstone(item) {
+				*items = append(*items, item)
+			}
+		}
+		nodesToSearch = append(nodesToSearch, node.children...)
+	}
+	return visited
+}
+
+// SearchFiltered returns all items within the area that are filtered.
+// If 'filter' returns false, the item is discarded.
+// If mustCover is true, items are only returned if they are fully within the search area.
+// If false, items are returned if they intersect the search area.
+func (r *RTree) SearchFiltered(area vmath.Rectf, mustCover bool, filter FilterFunc) []Item {
+	r.flush()
+	area = area.Normalize()
+	root := r.root.Load()
+	if !area.Intersects(root.bounds) {
+		return nil
+	}
+
+	var items []Item
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = root
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, child := range node.children {
+			if !area.Intersects(child.bounds) {
+				continue
+			}
+			if area.ContainsRectf(child.bounds) {
+				r.addAllFilteredItems(child, &items, filter)
+			} else {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
+			if !filter(item) {
+				continue
+			}
+			if (mustCover && area.ContainsRectf(item.Bounds())) ||
+				(!mustCover && area.Intersects(item.Bounds())) {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// SearchOutside returns all items that are at least partly outside the area.
+// These are all items that are not returned by Search(area, true).
+func (r *RTree) SearchOutside(area vmath.Rectf) []Item {
+	r.flush()
+	area = area.Normalize()
+
+	var items []Item
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		if area.ContainsRectf(node.bounds) {
+			continue // everything inside
+		}
+		if !area.Intersects(node.bounds) {
+			r.addAllItemsN(node, &items, maxInt) // everything outside
+			continue
+		}
+
+		nodesToSearch = append(nodesToSearch, node.children...)
+		for _, item := range node.items {
+			if !isTombstone(item) && !area.ContainsRectf(item.Bounds()) {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// SearchMinOverlap returns all items where at least the given fraction of their area overlaps with the search area.
+// Items without area (points and lines) are treated as fully overlapping if they intersect the search area.
+func (r *RTree) SearchMinOverlap(area vmath.Rectf, minFraction float32) []Item {
+	area = area.Normalize()
+	items := r.Search(area, false)
+
+	cnt := 0
+	for _, item := range items {
+		bounds := item.Bounds()
+		itemArea := bounds.Area()
+		if itemArea == 0 || overlapArea(bounds, area)/itemArea >= minFraction {
+			items[cnt] = item
+			cnt++
+		}
+	}
+	return items[:cnt]
+}
+
+// SearchByOverlap returns all items intersecting the area,
+// sorted by the size of the overlap between the item and the area, starting with the largest one.
+func (r *RTree) SearchByOverlap(area vmath.Rectf) []Item {
+	area = area.Normalize()
+	items := r.Search(area, false)
+	type overlapItem struct {
+		item    Item
+		overlap float32
+	}
+	sorted := make([]overlapItem, len(items))
+	for i, item := range items {
+		sorted[i] = overlapItem{item, overlapArea(item.Bounds(), area)}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].overlap > sorted[j].overlap
+	})
+	for i := range sorted {
+		items[i] = sorted[i].item
+	}
+	return items
+}
+
+// BestOverlap returns the item with the largest overlap with the area, together with the size of the overlap.
+// Items that only touch the area have an overlap of 0.
+// Returns false if no item intersects the area.
+func (r *RTree) BestOverlap(area vmath.Rectf) (Item, float32, bool) {
+	r.flush()
+	area = area.Normalize()
+	var best Item
+	bestOverlap := float32(-1)
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, child := range node.children {
+			// the overlap of a node limits the overlap of all contained items
+			if area.Intersects(child.bounds) && overlapArea(child.bounds, area) > bestOverlap {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+		for _, item := range node.items {
+			if isTombstone(item) || !area.Intersects(item.Bounds()) {
+				continue
+			}
+			if overlap := overlapArea(item.Bounds(), area); overlap > bestOverlap {
+				best, bestOverlap = item, overlap
+			}
+		}
+	}
+	if best == nil {
+		return nil, 0, false
+	}
+	return best, bestOverlap, true
+}
+
+// SearchSortedBy returns all items within the area, sorted by the given comparator.
+// The sort is stable with respect to the (undefined) search order.
+// If mustCover is true, items are only returned if they are fully within the search area.
+// If false, items are returned if they intersect the search area.
+func (r *RTree) SearchSortedBy(area vmath.Rectf, mustCover bool, less func(a, b Item) bool) []Item {
+	items := r.Search(area, mustCover)
+	sort.SliceStable(items, func(i, j int) bool {
+		return less(items[i], items[j])
+	})
+	return items
+}
+
+// defaultZOrderBits is the default number of bits per axis used by SearchZOrder.
+const defaultZOrderBits = 16
+
+// SearchZOrder returns all items within the area, sorted by the Morton code (Z-order curve) of their centers.
+// Items that are close to each other are therefore usually close to each other in the result.
+// The curve spans the search area with 'bits' bits per axis (between 1 and 32). If bits is <= 0, 16 bits are used.
+// Item centers outside the search area are clamped onto its edges.
+// If mustCover is true, items are only returned if they are fully within the search area.
+// If false, items are returned if they intersect the search area.
+func (r *RTree) SearchZOrder(area vmath.Rectf, mustCover bool, bits int) []Item {
+	if bits <= 0 {
+		bits = defaultZOrderBits
+	}
+	bits = mathi.Min(bits, 32)
+	area = area.Normalize()
+	items := r.Search(area, mustCover)
+
+	cells := uint64(1) << bits
+	size := area.Size()
+	type zItem struct {
+		item Item
+		code uint64
+	}
+	sorted := make([]zItem, len(items))
+	for i, item := range items {
+		var cell [2]uint32
+		pos := center(item.Bounds())
+		for dim := range pos {
+			if size[dim] > 0 {
+				rel := vmath.Clampf((pos[dim]-area.Min[dim])/size[dim], 0, 1)
+				cell[dim] = uint32(min(uint64(float64(rel)*float64(cells)), cells-1))
+			}
+		}
+		sorted[i] = zItem{item, mortonCode(cell[0], cell[1])}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].code < sorted[j].code
+	})
+	for i := range sorted {
+		items[i] = sorted[i].item
+	}
+	return items
+}
+
+// mortonCode interleaves the bits of x and y, with x occupying the lower bit of each pair.
+func mortonCode(x, y uint32) uint64 {
+	return spreadBits(x) | spreadBits(y)<<1
+}
+
+// spreadBits inserts a zero bit between each bit of v.
+func spreadBits(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// SearchBuffered returns all items that intersect the area after growing their bounds by 'margin' on all sides.
+// A negative margin shrinks the item bounds instead. Items that are smaller than the shrinkage are never returned.
+func (r *RTree) SearchBuffered(area vmath.Rectf, margin float32) []Item {
+	r.flush()
+	// Growing the item is equivalent to growing the search area.
+	// The grown area is intentionally not normalized, so that shrinking works as well.
+	area = inflate(area.Normalize(), margin)
+	root := r.root.Load()
+	if !area.Intersects(root.bounds) {
+		return nil
+	}
+
+	var items []Item
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = root
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, child := range node.children {
+			if area.Intersects(child.bounds) {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
+			bounds := item.Bounds()
+			if margin < 0 && (bounds.Size()[0] < -2*margin || bounds.Size()[1] < -2*margin) {
+				continue // shrunk to nothing
+			}
+			if area.Intersects(bounds) {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// SearchBounds returns the bounding box of all items within the area, and the number of those items.
+// If mustCover is true, items are only considered if they are fully within the search area.
+// If false, items are considered if they intersect the search area.
+// Returns an infinitely small bounding box and 0 if there are no such items.
+func (r *RTree) SearchBounds(area vmath.Rectf, mustCover bool) (vmath.Rectf, int) {
+	r.flush()
+	bounds := noBounds
+	count := 0
+	r.iterateSearch(area, mustCover, func(item Item) bool {
+		extend(&bounds, item.Bounds())
+		count++
+		return false
+	})
+	return bounds, count
+}
+
+// SearchMetric returns all items within the given distance of pos, measured with the given metric.
+// Nodes are pruned by their own distance to pos, which is a lower bound for the distance of all contained items.
+func (r *RTree) SearchMetric(pos vmath.Vec2f, dist float32, metric DistanceFunc) []Item {
+	r.flush()
+	root := r.root.Load()
+	if metric(pos, root.bounds) > dist {
+		return nil
+	}
+
+	var items []Item
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = root
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		for _, child := range node.children {
+			if metric(pos, child.bounds) <= dist {
+				nodesToSearch = append(nodesToSearch, child)
+			}
+		}
+		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
+			if metric(pos, item.Bounds()) <= dist {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// DensestRegion returns a region of the given size that contains the most item centers, and the number of contained centers.
+//
+// The result is an approximation: Instead of sliding the region over the whole tree,
+// only regions centered on the mean item center of each leaf node are evaluated.
+// Returns an empty region and 0 if the tree is empty.
+func (r *RTree) DensestRegion(size vmath.Vec2f) (vmath.Rectf, int) {
+	r.flush()
+	halfSize := size.Abs().MulScalar(0.5)
+
+	var densest vmath.Rectf
+	maxCount := 0
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+
+		var mean vmath.Vec2f
+		itemCount := 0
+		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
+			mean = mean.Add(center(item.Bounds()))
+			itemCount++
+		}
+		if itemCount == 0 {
+			continue
+		}
+		mean = mean.DivScalar(float32(itemCount))
+
+		region := vmath.Rectf{
+			Min: mean.Sub(halfSize),
+			Max: mean.Add(halfSize),
+		}
+		count := 0
+		r.iterateSearch(region, false, func(item Item) bool {
+			if region.ContainsPoint(center(item.Bounds())) {
+				count++
+			}
+			return false
+		})
+		if count > maxCount {
+			maxCount = count
+			densest = region
+		}
+	}
+	return densest, maxCount
+}
+
+// iterateSearch calls the provided function for every item within the area until true (=abort) is returned.
+// Returns true if the iteration was aborted.
+func (r *RTree) iterateSearch(area vmath.Rectf, mustCover bool, fn func(item Item) bool) bool {
 	area = area.Normalize()
-	if !area.Intersects(r.root.bounds) {
-		return nil
+	root := r.root.Load()
+	if !area.Intersects(root.bounds) {
+		return false
 	}
 
-	var items []Item
-
 	nodesToSearch := make([]*node, 1)
-	nodesToSearch[0] = r.root
+	nodesToSearch[0] = root
 	for len(nodesToSearch) > 0 {
 		node := popNode(&nodesToSearch)
 
@@ -62,65 +704,47 @@ func (r *RTree) search(area vmath.Rectf, mustCover bool, maxResults int) []Item
 				continue
 			}
 			if area.ContainsRectf(child.bounds) {
-				r.addAllItemsN(child, &items, maxResults)
-				if len(items) >= maxResults {
-					return items
+				if iterateAllItems(child, fn) {
+					return true
 				}
 			} else {
 				nodesToSearch = append(nodesToSearch, child)
 			}
 		}
 		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
 			if (mustCover && area.ContainsRectf(item.Bounds())) ||
 				(!mustCover && area.Intersects(item.Bounds())) {
-				items = append(items, item)
-				if len(items) >= maxResults {
-					return items
+				if fn(item) {
+					return true
 				}
 			}
 		}
 	}
-	return items
+	return false
 }
 
-// SearchFiltered returns all items within the area that are filtered.
-// If 'filter' returns false, the item is discarded.
-// If mustCover is true, items are only returned if they are fully within the search area.
-// If false, items are returned if they intersect the search area.
-func (r *RTree) SearchFiltered(area vmath.Rectf, mustCover bool, filter FilterFunc) []Item {
-	area = area.Normalize()
-	if !area.Intersects(r.root.bounds) {
-		return nil
-	}
-
-	var items []Item
-
+// iterateAllItems calls the provided function for every item within the subtree until true (=abort) is returned.
+// Returns true if the iteration was aborted.
+func iterateAllItems(root *node, fn func(item Item) bool) bool {
 	nodesToSearch := make([]*node, 1)
-	nodesToSearch[0] = r.root
+	nodesToSearch[0] = root
 	for len(nodesToSearch) > 0 {
 		node := popNode(&nodesToSearch)
 
-		for _, child := range node.children {
-			if !area.Intersects(child.bounds) {
-				continue
-			}
-			if area.ContainsRectf(child.bounds) {
-				r.addAllFilteredItems(child, &items, filter)
-			} else {
-				nodesToSearch = append(nodesToSearch, child)
-			}
-		}
 		for _, item := range node.items {
-			if !filter(item) {
+			if isTombstone(item) {
 				continue
 			}
-			if (mustCover && area.ContainsRectf(item.Bounds())) ||
-				(!mustCover && area.Intersects(item.Bounds())) {
-				items = append(items, item)
+			if fn(item) {
+				return true
 			}
 		}
+		nodesToSearch = append(nodesToSearch, node.children...)
 	}
-	return items
+	return false
 }
 
 func (r *RTree) addAllItemsN(root *node, items *[]Item, maxLen int) {
@@ -129,7 +753,11 @@ func (r *RTree) addAllItemsN(root *node, items *[]Item, maxLen int) {
 	for len(nodesToSearch) > 0 {
 		node := popNode(&nodesToSearch)
 
-		*items = append(*items, node.items...)
+		for _, item := range node.items {
+			if !isTombstone(item) {
+				*items = append(*items, item)
+			}
+		}
 		if len(*items) >= maxLen {
 			*items = (*items)[:maxLen]
 			return
@@ -146,6 +774,9 @@ func (r *RTree) addAllFilteredItems(root *node, items *[]Item, filter FilterFunc
 		nodesToSearch = append(nodesToSearch, node.children...)
 
 		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
 			if filter(item) {
 				*items = append(*items, item)
 			}
@@ -153,15 +784,131 @@ func (r *RTree) addAllFilteredItems(root *node, items *[]Item, filter FilterFunc
 	}
 }
 
+// IsReachable returns true if the item is found when searching at its own bounds.
+// equalsFn is optional. It is useful if you only have a copy of the originally inserted item.
+// This is a diagnostic function: every stored item must be reachable, unless its bounds changed after insertion.
+func (r *RTree) IsReachable(item Item, equalsFn EqualsFunc) bool {
+	r.flush()
+	return r.iterateSearch(item.Bounds(), false, func(other Item) bool {
+		return itemsEqual(item, other, equalsFn)
+	})
+}
+
+// estimateDepth is the number of tree levels below the root that are visited by EstimateCount.
+const estimateDepth = 2
+
+// estimateSamples is the min. number of leaves per node that EstimateCount uses to extrapolate the node's items.
+const estimateSamples = 32
+
+// EstimateCount returns an estimate of the number of items intersecting the area.
+// Only the top levels of the tree are searched. For nodes below, the items are counted without looking at their bounds,
+// and the fraction of them intersecting the area is extrapolated from a sample of leaves.
+// The result is only an approximation and can deviate from the exact number, especially for unevenly distributed items.
+func (r *RTree) EstimateCount(area vmath.Rectf) int {
+	r.flush()
+	area = area.Normalize()
+	estimate := float32(0)
+	nodesToSearch := []*node{r.root.Load()}
+	depths := []int{0}
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		depth := popInt(&depths)
+		if !area.Intersects(node.bounds) {
+			continue
+		}
+		if node.leaf {
+			for _, item := range node.items {
+				if !isTombstone(item) && area.Intersects(item.Bounds()) {
+					estimate++
+				}
+			}
+			continue
+		}
+
+		if depth < estimateDepth {
+			for _, child := range node.children {
+				nodesToSearch = append(nodesToSearch, child)
+				depths = append(depths, depth+1)
+			}
+			continue
+		}
+
+		count := float32(countSubtreeItems(node, nil))
+		if !area.ContainsRectf(node.bounds) {
+			count *= sampleFraction(node, area)
+		}
+		estimate += count
+	}
+	return int(math32.Round(estimate))
+}
+
+// sampleFraction estimates the fraction of the subtree's items that intersect the area.
+// The items of at least estimateSamples leaves, spread across the subtree, are used as a sample.
+func sampleFraction(nod *node, area vmath.Rectf) float32 {
+	// descend until there are enough nodes to choose a leaf from
+	samples := []*node{nod}
+	for len(samples) < estimateSamples && !samples[0].leaf {
+		var children []*node
+		for _, sample := range samples {
+			children = append(children, sample.children...)
+		}
+		if len(children) == 0 {
+			break
+		}
+		samples = children
+	}
+
+	hits, cnt := 0, 0
+	for i, leaf := range samples {
+		for !leaf.leaf && len(leaf.children) > 0 {
+			leaf = leaf.children[i%len(leaf.children)] // vary the path, to avoid a bias towards certain positions
+		}
+		for _, item := range leaf.items {
+			if isTombstone(item) {
+				continue
+			}
+			cnt++
+			if area.Intersects(item.Bounds()) {
+				hits++
+			}
+		}
+	}
+	if cnt == 0 {
+		return 0
+	}
+	return float32(hits) / float32(cnt)
+}
+
 // Intersects returns true if there are any items overlapping with the given area.
-// Touching rectangles where floats are exactly equal are not considered to intersect.
+// Touching rectangles where floats are exactly equal are considered to intersect.
 func (r *RTree) Intersects(area vmath.Rectf) bool {
+	r.flush()
+	return r.intersects(area)
+}
+
+// IntersectsBatch checks for every area if there are any items overlapping with it, like Intersects.
+// The result is aligned by index with 'areas'.
+//
+// Queries are executed in parallel on the given number of workers.
+// If workers is <= 0, the number of CPUs is used.
+// The tree must not be modified until the function returns.
+func (r *RTree) IntersectsBatch(areas []vmath.Rectf, workers int) []bool {
+	r.flush()
+	results := make([]bool, len(areas))
+	parallelize(len(areas), workers, func(i int) {
+		results[i] = r.intersects(areas[i])
+	})
+	return results
+}
+
+func (r *RTree) intersects(area vmath.Rectf) bool {
 	area = area.Normalize()
-	if !area.Intersects(r.root.bounds) {
+	root := r.root.Load()
+	if !area.Intersects(root.bounds) {
 		return false
 	}
 	nodesToSearch := make([]*node, 1)
-	nodesToSearch[0] = r.root
+	nodesToSearch[0] = root
 	for len(nodesToSearch) > 0 {
 		node := popNode(&nodesToSearch)
 
@@ -170,12 +917,17 @@ func (r *RTree) Intersects(area vmath.Rectf) bool {
 				continue
 			}
 			if area.ContainsRectf(child.bounds) {
-				return true
+				if r.tombstones == 0 || iterateAllItems(child, func(Item) bool { return true }) {
+					return true
+				}
 			} else {
 				nodesToSearch = append(nodesToSearch, child)
 			}
 		}
 		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
 			if area.Intersects(item.Bounds()) {
 				return true
 			}
@@ -187,21 +939,53 @@ func (r *RTree) Intersects(area vmath.Rectf) bool {
 // NearestNeighbor returns the item that is closest to the given position.
 // Returns nil if the tree is empty.
 func (r *RTree) NearestNeighbor(pos vmath.Vec2f) Item {
-	item, _ := r.nearestNeighbor(pos, r.root, nil, math32.Infinity)
+	r.flush()
+	item, _ := r.nearestNeighbor(pos, r.root.Load(), nil, math32.Infinity)
 	return item
 }
 
+// NearestNeighborCovering returns the item that is closest to the given position,
+// and whether the position lies within the item's bounds (edges are inclusive).
+// Returns nil if the tree is empty.
+func (r *RTree) NearestNeighborCovering(pos vmath.Vec2f) (Item, bool) {
+	r.flush()
+	item, sqDist := r.nearestNeighbor(pos, r.root.Load(), nil, math32.Infinity)
+	return item, item != nil && sqDist == 0
+}
+
+// NearestNeighborPoint returns the item that is closest to the given position,
+// together with the point on the item's bounds that is closest to the position.
+// If the position is within the item's bounds, the position itself is returned.
+// Returns false if the tree is empty.
+func (r *RTree) NearestNeighborPoint(pos vmath.Vec2f) (Item, vmath.Vec2f, bool) {
+	r.flush()
+	item, _ := r.nearestNeighbor(pos, r.root.Load(), nil, math32.Infinity)
+	if item == nil {
+		return nil, vmath.Vec2f{}, false
+	}
+	bounds := item.Bounds()
+	closest := vmath.Vec2f{
+		vmath.Clampf(pos[0], bounds.Min[0], bounds.Max[0]),
+		vmath.Clampf(pos[1], bounds.Min[1], bounds.Max[1]),
+	}
+	return item, closest, true
+}
+
 // NearestNeighbor returns the item that is closest to the given position but within the given max. distance.
 // Returns nil if the tree is empty or if there are no items within the given distance.
 func (r *RTree) NearestNeighborWithin(pos vmath.Vec2f, maxDistance float32) Item {
+	r.flush()
 	maxSqDist := maxDistance * maxDistance
-	item, _ := r.nearestNeighbor(pos, r.root, nil, maxSqDist)
+	item, _ := r.nearestNeighbor(pos, r.root.Load(), nil, maxSqDist)
 	return item
 }
 
 func (r *RTree) nearestNeighbor(pos vmath.Vec2f, node *node, nearest Item, nearestSqDist float32) (Item, float32) {
 	if node.leaf {
 		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
 			itemDist := item.Bounds().SquarePointDistance(pos)
 			if itemDist < nearestSqDist {
 				nearestSqDist = itemDist
@@ -213,8 +997,11 @@ func (r *RTree) nearestNeighbor(pos vmath.Vec2f, node *node, nearest Item, neare
 
 	// Sort nodes to visit most promising children first
 	children := sortNodesByDistance(pos, node.children)
-	// Prune nodes that can't contain the nearest neighbour
-	children = pruneNodes(pos, nearestSqDist, children)
+	// Prune nodes that can't contain the nearest neighbour.
+	// This requires tight bounding boxes, which are not guaranteed if there are tombstones.
+	if r.tombstones == 0 {
+		children = pruneNodes(pos, nearestSqDist, children)
+	}
 
 	for idx, child := range children.nodes {
 		dist := children.sqDistances[idx]
@@ -230,6 +1017,49 @@ func (r *RTree) nearestNeighbor(pos vmath.Vec2f, node *node, nearest Item, neare
 	return nearest, nearestSqDist
 }
 
+// NearestPerSector returns the closest item within each of the given number of angular sectors around pos.
+// Sectors are of equal size and numbered counter-clockwise, with sector 0 starting at the positive x-axis.
+// Items are assigned to a sector by the direction of their center.
+// The returned slice contains one entry per sector, which is nil if there are no items within that sector.
+func (r *RTree) NearestPerSector(pos vmath.Vec2f, sectors int) []Item {
+	r.flush()
+	if sectors <= 0 {
+		return nil
+	}
+	nearest := make([]Item, sectors)
+	found := 0
+	sectorSize := 2 * math.Pi / float32(sectors)
+
+	queue := entryQueue{{node: r.root.Load()}}
+	for len(queue) > 0 && found < sectors {
+		entry := heap.Pop(&queue).(queueEntry)
+
+		if entry.item != nil {
+			angle := center(entry.item.Bounds()).Sub(pos).FlatAngle()
+			if angle < 0 {
+				angle += 2 * math.Pi
+			}
+			sector := mathi.Min(int(angle/sectorSize), sectors-1)
+			if nearest[sector] == nil {
+				nearest[sector] = entry.item
+				found++
+			}
+			continue
+		}
+
+		for _, item := range entry.node.items {
+			if isTombstone(item) {
+				continue
+			}
+			heap.Push(&queue, queueEntry{item: item, dist: item.Bounds().SquarePointDistance(pos)})
+		}
+		for _, child := range entry.node.children {
+			heap.Push(&queue, queueEntry{node: child, dist: child.bounds.SquarePointDistance(pos)})
+		}
+	}
+	return nearest
+}
+
 // sortNodesByDistance reorders the node slice by distance to the given position.
 // returns the sorted nodes and their squared distance.
 func sortNodesByDistance(pos vmath.Vec2f, nodes []*node) nodesByDistance {
@@ -254,6 +1084,9 @@ func pruneNodes(pos vmath.Vec2f, nearestSqDist float32, sortedNodes nodesByDista
 	// Calculate the min. distance within which it's guaranteed that there is an item
 	minMinMaxDist := nearestSqDist
 	for _, node := range sortedNodes.nodes {
+		if node.bounds == noBounds { // empty node (see SetCondense)
+			continue
+		}
 		minMaxDist := minMaxDist(pos, node.bounds)
 		minMinMaxDist = math32.Min(minMaxDist, minMinMaxDist)
 	}
@@ -314,17 +1147,65 @@ func minMaxDist(pos vmath.Vec2f, r vmath.Rectf) float32 {
 // IterateAllItems calls the provided function for every stored item until true (=abort) is returned.
 // The order in which items are iterated is undefined.
 func (r *RTree) IterateItems(fn func(item Item) bool) {
+	r.flush()
+	iterateAllItems(r.root.Load(), fn)
+}
+
+// IterateItemsDFS calls the provided function for every stored item until true (=abort) is returned.
+// Items are iterated depth-first, leaf by leaf, in the order in which they are stored within the tree.
+// Items of the same leaf are visited consecutively, which provides good spatial locality.
+func (r *RTree) IterateItemsDFS(fn func(item Item) bool) {
+	r.flush()
 	nodesToSearch := make([]*node, 1)
-	nodesToSearch[0] = r.root
+	nodesToSearch[0] = r.root.Load()
 	for len(nodesToSearch) > 0 {
 		node := popNode(&nodesToSearch)
 
 		for _, item := range node.items {
+			if isTombstone(item) {
+				continue
+			}
 			if fn(item) {
 				return
 			}
 		}
-		nodesToSearch = append(nodesToSearch, node.children...)
+		// push in reverse order to visit the first child next
+		for i := len(node.children) - 1; i >= 0; i-- {
+			nodesToSearch = append(nodesToSearch, node.children[i])
+		}
+	}
+}
+
+// IterateLeaves calls the provided function for every leaf node until true (=abort) is returned.
+// Leaves are visited depth-first, in the order in which they are stored within the tree,
+// so that leaves that are close to each other are usually visited consecutively.
+// The item slice must not be modified. Empty leaves are skipped.
+func (r *RTree) IterateLeaves(fn func(bounds vmath.Rectf, items []Item) bool) {
+	r.flush()
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		if node.leaf {
+			items := node.items
+			if r.tombstones > 0 {
+				items = make([]Item, 0, len(node.items))
+				for _, item := range node.items {
+					if !isTombstone(item) {
+						items = append(items, item)
+					}
+				}
+			}
+			if len(items) > 0 && fn(node.bounds, items) {
+				return
+			}
+			continue
+		}
+		// push in reverse order to visit the first child next
+		for i := len(node.children) - 1; i >= 0; i-- {
+			nodesToSearch = append(nodesToSearch, node.children[i])
+		}
 	}
 }
 
@@ -332,8 +1213,9 @@ func (r *RTree) IterateItems(fn func(item Item) bool) {
 // The order in which nodes are iterated is undefined.
 // This function is useful for graphically visualizing the R-Tree internals.
 func (r *RTree) IterateInternalNodes(fn func(bounds vmath.Rectf, height int, leaf bool) bool) {
+	r.flush()
 	nodesToSearch := make([]*node, 1)
-	nodesToSearch[0] = r.root
+	nodesToSearch[0] = r.root.Load()
 	for {
 		if len(nodesToSearch) == 0 {
 			return
@@ -347,30 +1229,328 @@ func (r *RTree) IterateInternalNodes(fn func(bounds vmath.Rectf, height int, lea
 	}
 }
 
+// NodeBoundsAtLevel returns the bounding boxes of all nodes with the given height.
+// Leaf nodes have a height of 1, the root node has a height of Height().
+// This function is useful for graphically visualizing the R-Tree internals.
+func (r *RTree) NodeBoundsAtLevel(height int) []vmath.Rectf {
+	r.flush()
+	var bounds []vmath.Rectf
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		if node.height == height {
+			bounds = append(bounds, node.bounds)
+		} else if node.height > height {
+			nodesToSearch = append(nodesToSearch, node.children...)
+		}
+	}
+	return bounds
+}
+
+// AreaPerLevel returns the summed area of all node bounding boxes, indexed by the nodes' height.
+// Leaf nodes have a height of 1; index 0 is unused. Smaller areas indicate a better packed tree.
+func (r *RTree) AreaPerLevel() []float32 {
+	r.flush()
+	root := r.root.Load()
+	areas := make([]float32, root.height+1)
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = root
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+		if node.bounds != noBounds {
+			areas[node.height] += node.bounds.Area()
+		}
+	}
+	return areas
+}
+
+// IterateInternalNodesWeighted works like IterateInternalNodes,
+// but additionally provides the total number of items within each node's subtree.
+func (r *RTree) IterateInternalNodesWeighted(fn func(bounds vmath.Rectf, height int, leaf bool, itemCount int) bool) {
+	r.flush()
+	counts := make(map[*node]int)
+	root := r.root.Load()
+	countSubtreeItems(root, counts)
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = root
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+
+		if fn(node.bounds, node.height, node.leaf, counts[node]) {
+			return
+		}
+		nodesToSearch = append(nodesToSearch, node.children...)
+	}
+}
+
+// SubtreeInfo calls the provided function for every tree node until true (=abort) is returned.
+// Nodes are visited in post-order (children before their parents) within a single pass.
+// Besides the information provided by IterateInternalNodes, the total number of items within the node's subtree
+// and the area of its bounding box (0 for empty nodes) are provided.
+func (r *RTree) SubtreeInfo(fn func(bounds vmath.Rectf, height int, leaf bool, itemCount int, area float32) bool) {
+	r.flush()
+	subtreeInfo(r.root.Load(), fn)
+}
+
+// subtreeInfo visits the subtree in post-order and returns the number of items within it,
+// as well as true if the iteration was aborted.
+func subtreeInfo(nod *node, fn func(bounds vmath.Rectf, height int, leaf bool, itemCount int, area float32) bool) (int, bool) {
+	cnt := 0
+	for _, item := range nod.items {
+		if !isTombstone(item) {
+			cnt++
+		}
+	}
+	for _, child := range nod.children {
+		childCnt, aborted := subtreeInfo(child, fn)
+		if aborted {
+			return 0, true
+		}
+		cnt += childCnt
+	}
+	area := float32(0)
+	if nod.bounds != noBounds {
+		area = nod.bounds.Area()
+	}
+	return cnt, fn(nod.bounds, nod.height, nod.leaf, cnt, area)
+}
+
+// countSubtreeItems returns the number of items within the node's subtree.
+// If 'counts' is not nil, the number of items is stored for every visited node.
+func countSubtreeItems(nod *node, counts map[*node]int) int {
+	cnt := 0
+	for _, item := range nod.items {
+		if !isTombstone(item) {
+			cnt++
+		}
+	}
+	for _, child := range nod.children {
+		cnt += countSubtreeItems(child, counts)
+	}
+	if counts != nil {
+		counts[nod] = cnt
+	}
+	return cnt
+}
+
 // Height returns the height of the R-Tree.
 // This function is useful for graphically visualizing the R-Tree internals.
 func (r *RTree) Height() int {
-	if r.root == nil {
+	r.flush()
+	root := r.root.Load()
+	if root == nil {
 		return 0
 	}
-	return r.root.height
+	return root.height
+}
+
+// LeafDepthRange returns the depths of the shallowest and deepest leaf nodes, where the root has a depth of 1.
+// In a well-formed tree, all leaves are at the same depth, which is equal to Height().
+func (r *RTree) LeafDepthRange() (min, max int) {
+	r.flush()
+	min = maxInt
+
+	nodesToSearch := []*node{r.root.Load()}
+	depths := []int{1}
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		depth := popInt(&depths)
+
+		if node.leaf {
+			min = mathi.Min(min, depth)
+			max = mathi.Max(max, depth)
+			continue
+		}
+		for _, child := range node.children {
+			nodesToSearch = append(nodesToSearch, child)
+			depths = append(depths, depth+1)
+		}
+	}
+	return min, max
 }
 
 // Bounds returns the bounding box of all items.
-// Returns an infinitely small bounding box if there are no items.
+// Returns an infinitely small bounding box if there are no items:
+// its Min is set to +infinity and its Max to -infinity, which must not be used for calculations.
+// Use BoundsOK to detect empty trees.
 func (r *RTree) Bounds() vmath.Rectf {
-	return r.root.bounds
+	r.flush()
+	return r.root.Load().bounds
+}
+
+// MightContain returns false if the area can't intersect any item, because it doesn't intersect Bounds().
+// Returns false if the tree is empty.
+func (r *RTree) MightContain(area vmath.Rectf) bool {
+	r.flush()
+	bounds := r.root.Load().bounds
+	return bounds != noBounds && area.Normalize().Intersects(bounds)
+}
+
+// AllWithin returns true if all items are fully within the area, because it contains Bounds().
+// Returns true if the tree is empty.
+// Items that were marked as removed (see MarkRemoved) still count until the tree is compacted.
+func (r *RTree) AllWithin(area vmath.Rectf) bool {
+	r.flush()
+	bounds := r.root.Load().bounds
+	return bounds == noBounds || area.Normalize().ContainsRectf(bounds)
+}
+
+// BoundsOK returns the bounding box of all items.
+// Returns false and a zero bounding box if there are no items.
+func (r *RTree) BoundsOK() (vmath.Rectf, bool) {
+	r.flush()
+	root := r.root.Load()
+	if root.bounds == noBounds {
+		return vmath.Rectf{}, false
+	}
+	if r.tombstones > 0 && !iterateAllItems(root, func(item Item) bool { return true }) {
+		return vmath.Rectf{}, false // only tombstones left
+	}
+	return root.bounds, true
+}
+
+// BoundingCircle returns the center and radius of a circle enclosing all items.
+// The circle is centered on the center of Bounds(), with the radius reaching the farthest item corner.
+// It is not necessarily the minimal enclosing circle, but never larger than the circle around Bounds().
+// Returns a zero circle if there are no items.
+func (r *RTree) BoundingCircle() (vmath.Vec2f, float32) {
+	r.flush()
+	root := r.root.Load()
+	if root.bounds == noBounds {
+		return vmath.Vec2f{}, 0
+	}
+	pos := center(root.bounds)
+	sqRadius := float32(0)
+	iterateAllItems(root, func(item Item) bool {
+		sqRadius = math32.Max(sqRadius, maxSqDistance(pos, item.Bounds()))
+		return false
+	})
+	return pos, math32.Sqrt(sqRadius)
+}
+
+// MostRedundantItem returns the item that contributes least to the bounding box of its leaf node.
+// This is the item where removing it shrinks the leaf's bounding box the least, or not at all.
+// This is a heuristic: the item is not necessarily the most redundant one in relation to all other items.
+// Returns false if the tree is empty.
+func (r *RTree) MostRedundantItem() (Item, bool) {
+	r.flush()
+	var best Item
+	bestShrinkage := math32.Infinity
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+
+		items := node.items
+		for i, item := range items {
+			if isTombstone(item) {
+				continue
+			}
+			others := noBounds
+			for j, other := range items {
+				if j != i && !isTombstone(other) {
+					extend(&others, other.Bounds())
+				}
+			}
+			shrinkage := item.Bounds().Area() // the only item within the leaf
+			if others != noBounds {
+				shrinkage = others.Merge(item.Bounds()).Area() - others.Area()
+			}
+			if shrinkage < bestShrinkage {
+				best, bestShrinkage = item, shrinkage
+				if shrinkage == 0 {
+					return best, true
+				}
+			}
+		}
+	}
+	return best, best != nil
+}
+
+// SizeHistogram counts the items by the area of their bounding boxes.
+// The range between the smallest and the largest area is divided into the given number of equally sized buckets.
+// Returns the number of items within each bucket, as well as the smallest and largest area.
+func (r *RTree) SizeHistogram(buckets int) ([]int, float32, float32) {
+	r.flush()
+	if buckets <= 0 {
+		return nil, 0, 0
+	}
+	histogram := make([]int, buckets)
+
+	var areas []float32
+	minArea, maxArea := math32.Infinity, float32(0)
+	iterateAllItems(r.root.Load(), func(item Item) bool {
+		area := item.Bounds().Area()
+		areas = append(areas, area)
+		minArea = math32.Min(minArea, area)
+		maxArea = math32.Max(maxArea, area)
+		return false
+	})
+	if len(areas) == 0 {
+		return histogram, 0, 0
+	}
+
+	bucketSize := (maxArea - minArea) / float32(buckets)
+	for _, area := range areas {
+		idx := 0
+		if bucketSize > 0 {
+			idx = mathi.Min(int((area-minArea)/bucketSize), buckets-1)
+		}
+		histogram[idx]++
+	}
+	return histogram, minArea, maxArea
 }
 
 // Size returns the total number of stored items.
 func (r *RTree) Size() int {
+	r.flush()
 	cnt := 0
 	nodesToSearch := make([]*node, 1)
-	nodesToSearch[0] = r.root
+	nodesToSearch[0] = r.root.Load()
 	for len(nodesToSearch) > 0 {
 		node := popNode(&nodesToSearch)
 		nodesToSearch = append(nodesToSearch, node.children...)
 		cnt += len(node.items)
 	}
-	return cnt
+	return cnt - r.tombstones
+}
+
+// LoadFactor returns the ratio between the number of stored items and the total capacity of all leaf nodes.
+// Freshly bulk-loaded trees have a load factor close to 1.
+// Values far below the minimum fill ratio of 40% indicate that rebuilding the tree is worthwhile (see Rebuild).
+func (r *RTree) LoadFactor() float32 {
+	r.flush()
+	items, leaves := 0, 0
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+		if node.leaf {
+			leaves++
+			items += len(node.items)
+		}
+	}
+	items -= r.tombstones
+	return float32(items) / float32(leaves*r.maxEntries)
+}
+
+// ContentHash combines the hashes of all stored items into a single value.
+// The combination is order-independent, so the result only depends on the stored items and not on the tree structure.
+// Two trees containing the same items (including duplicates) therefore produce the same hash.
+func (r *RTree) ContentHash(hash func(item Item) uint64) uint64 {
+	r.flush()
+	var sum uint64
+	iterateAllItems(r.root.Load(), func(item Item) bool {
+		sum += hash(item) // addition is commutative and, unlike xor, does not cancel out duplicates
+		return false
+	})
+	return sum
 }
@@ -1,9 +1,13 @@
 package rtree
 
 import (
+	"math"
+	"math/rand"
 	"testing"
 
 	"github.com/maja42/vmath"
+	"github.com/maja42/vmath/math32"
+	"github.com/maja42/vmath/mathi"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -46,3 +50,815 @@ func TestMinMaxDist(t *testing.T) {
 	mmd = minMaxDist(pos, r)
 	assert.Equal(t, expected, mmd)
 }
+
+func TestNearestPerSector(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{50, 50}
+	sectors := 8
+
+	expected := make([]float32, sectors)
+	for i := range expected {
+		expected[i] = math32.Infinity
+	}
+	for _, item := range items {
+		b := item.Bounds()
+		angle := b.Min.Add(b.Max).MulScalar(0.5).Sub(pos).FlatAngle()
+		if angle < 0 {
+			angle += 2 * math.Pi
+		}
+		sector := mathi.Min(int(angle/(2*math.Pi/float32(sectors))), sectors-1)
+		expected[sector] = math32.Min(expected[sector], b.SquarePointDistance(pos))
+	}
+
+	nearest := tree.NearestPerSector(pos, sectors)
+	assert.Len(t, nearest, sectors)
+	for sector, item := range nearest {
+		assert.Equal(t, expected[sector], item.Bounds().SquarePointDistance(pos), "sector %d", sector)
+	}
+
+	assert.Equal(t, []Item{nil, nil, nil, nil}, New().NearestPerSector(pos, 4))
+}
+
+func TestSearch_PointItems(t *testing.T) {
+	tree := New()
+	var items []Item
+	for x := 0; x <= 20; x++ {
+		for y := 0; y <= 20; y++ {
+			pos := vmath.Vec2f{float32(x), float32(y)}
+			items = append(items, &testItem{bounds: vmath.Rectf{Min: pos, Max: pos}})
+		}
+	}
+	tree.BulkLoad(items)
+
+	for _, item := range items {
+		assert.Equal(t, []Item{item}, tree.SearchPos(item.Bounds().Min))
+	}
+
+	// points on the boundary of the search area are included
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{5, 5},
+		Max: vmath.Vec2f{10, 8},
+	}
+	assert.Len(t, tree.Search(area, false), 6*4)
+	assert.Len(t, tree.Search(area, true), 6*4)
+
+	// zero-area search areas
+	line := vmath.Rectf{
+		Min: vmath.Vec2f{3, 0},
+		Max: vmath.Vec2f{3, 20},
+	}
+	assert.Len(t, tree.Search(line, false), 21)
+	assert.Len(t, tree.Search(line, true), 21)
+	assert.True(t, tree.Intersects(line))
+}
+
+func TestSearchBuffered(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{40, 40},
+		Max: vmath.Vec2f{45, 50},
+	}
+
+	for _, margin := range []float32{0, 3, -3} {
+		var expected []Item
+		for _, item := range items {
+			b := item.Bounds()
+			grown := vmath.Rectf{
+				Min: b.Min.SubScalar(margin),
+				Max: b.Max.AddScalar(margin),
+			}
+			if grown.Min[0] <= grown.Max[0] && grown.Min[1] <= grown.Max[1] && grown.Intersects(area) {
+				expected = append(expected, item)
+			}
+		}
+		assert.ElementsMatch(t, expected, tree.SearchBuffered(area, margin), "margin %v", margin)
+	}
+}
+
+func TestDensestRegion(t *testing.T) {
+	tree, _ := newPrePopulatedTree(500)
+
+	// add a dense cluster
+	for i := 0; i < 100; i++ {
+		pos := vmath.Vec2f{70 + rand.Float32(), 20 + rand.Float32()}
+		tree.Insert(&testItem{bounds: vmath.Rectf{Min: pos, Max: pos}})
+	}
+
+	region, count := tree.DensestRegion(vmath.Vec2f{2, 2})
+	assert.GreaterOrEqual(t, count, 50)
+	assert.True(t, region.ContainsPoint(vmath.Vec2f{70.5, 20.5}))
+
+	_, count = New().DensestRegion(vmath.Vec2f{2, 2})
+	assert.Equal(t, 0, count)
+}
+
+func TestSearchMetric(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	pos := vmath.Vec2f{20, 80}
+
+	for _, metric := range []DistanceFunc{EuclideanDistance, ManhattanDistance, ChebyshevDistance} {
+		var expected []Item
+		for _, item := range items {
+			if metric(pos, item.Bounds()) <= 10 {
+				expected = append(expected, item)
+			}
+		}
+		assert.ElementsMatch(t, expected, tree.SearchMetric(pos, 10, metric))
+	}
+
+	r := vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{1, 1}}
+	assert.Equal(t, float32(7), ManhattanDistance(vmath.Vec2f{4, 5}, r))
+	assert.Equal(t, float32(4), ChebyshevDistance(vmath.Vec2f{4, 5}, r))
+	assert.Equal(t, float32(0), ChebyshevDistance(vmath.Vec2f{0.5, 0.5}, r))
+}
+
+func TestSearchBounds(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{10, 10},
+		Max: vmath.Vec2f{60, 40},
+	}
+
+	expected := noBounds
+	items := tree.Search(area, true)
+	for _, item := range items {
+		extend(&expected, item.Bounds())
+	}
+	bounds, count := tree.SearchBounds(area, true)
+	assert.Equal(t, expected, bounds)
+	assert.Equal(t, len(items), count)
+
+	bounds, count = New().SearchBounds(area, false)
+	assert.Equal(t, noBounds, bounds)
+	assert.Equal(t, 0, count)
+}
+
+func TestIterateInternalNodesWeighted(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+
+	leafItems := 0
+	tree.IterateInternalNodesWeighted(func(bounds vmath.Rectf, height int, leaf bool, itemCount int) bool {
+		if height == tree.Height() {
+			assert.Equal(t, len(items), itemCount)
+		}
+		if leaf {
+			leafItems += itemCount
+		}
+		assert.GreaterOrEqual(t, len(tree.Search(bounds, true)), itemCount)
+		return false
+	})
+	assert.Equal(t, len(items), leafItems)
+}
+
+func TestSubtreeInfo(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+
+	visited, leafItems := 0, 0
+	lastHeight := 0
+	tree.SubtreeInfo(func(bounds vmath.Rectf, height int, leaf bool, itemCount int, area float32) bool {
+		visited++
+		if leaf {
+			leafItems += itemCount
+		}
+		assert.Equal(t, bounds.Area(), area)
+		assert.GreaterOrEqual(t, len(tree.Search(bounds, true)), itemCount)
+		lastHeight = height
+		return false
+	})
+	assert.Equal(t, len(items), leafItems)
+	assert.Equal(t, tree.Height(), lastHeight) // the root is visited last
+
+	nodes := 0
+	tree.IterateInternalNodes(func(vmath.Rectf, int, bool) bool {
+		nodes++
+		return false
+	})
+	assert.Equal(t, nodes, visited)
+
+	visited = 0
+	tree.SubtreeInfo(func(bounds vmath.Rectf, height int, leaf bool, itemCount int, area float32) bool {
+		visited++
+		assert.True(t, leaf) // the first visited node is a leaf
+		return true
+	})
+	assert.Equal(t, 1, visited)
+
+	New().SubtreeInfo(func(bounds vmath.Rectf, height int, leaf bool, itemCount int, area float32) bool {
+		assert.Equal(t, 0, itemCount)
+		assert.Equal(t, float32(0), area)
+		return false
+	})
+}
+
+func TestSearchSortedBy(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{10, 10},
+		Max: vmath.Vec2f{60, 40},
+	}
+
+	byArea := func(a, b Item) bool {
+		return a.Bounds().Area() < b.Bounds().Area()
+	}
+	items := tree.SearchSortedBy(area, false, byArea)
+	assert.ElementsMatch(t, tree.Search(area, false), items)
+	for i := 1; i < len(items); i++ {
+		assert.False(t, byArea(items[i], items[i-1]))
+	}
+}
+
+func TestBoundingCircle(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+
+	pos, radius := tree.BoundingCircle()
+	assert.Equal(t, center(tree.Bounds()), pos)
+	halfDiagonal := tree.Bounds().Size().Length() / 2
+	assert.LessOrEqual(t, radius, halfDiagonal*1.0001)
+	for _, item := range items {
+		assert.LessOrEqual(t, math32.Sqrt(maxSqDistance(pos, item.Bounds())), radius)
+	}
+
+	pos, radius = New().BoundingCircle()
+	assert.Equal(t, vmath.Vec2f{}, pos)
+	assert.Equal(t, float32(0), radius)
+}
+
+func TestSearchByOverlap(t *testing.T) {
+	tree := New()
+	covered := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{2, 2}, Max: vmath.Vec2f{4, 4}}}
+	partial := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{-1, -1}, Max: vmath.Vec2f{1, 1}}}
+	touching := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{10, 0}, Max: vmath.Vec2f{12, 2}}}
+	outside := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{20, 20}, Max: vmath.Vec2f{21, 21}}}
+	tree.Insert(touching).Insert(partial).Insert(covered).Insert(outside)
+
+	area := vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{10, 10}}
+	assert.Equal(t, []Item{covered, partial, touching}, tree.SearchByOverlap(area))
+}
+
+func TestOverlapArea(t *testing.T) {
+	a := vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{4, 4}}
+	assert.Equal(t, float32(4), overlapArea(a, vmath.Rectf{Min: vmath.Vec2f{2, 2}, Max: vmath.Vec2f{6, 6}}))
+	assert.Equal(t, float32(16), overlapArea(a, a))
+	assert.Equal(t, float32(0), overlapArea(a, vmath.Rectf{Min: vmath.Vec2f{5, 5}, Max: vmath.Vec2f{6, 6}}))
+}
+
+func TestIterateItemsDFS(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+
+	var expected []Item
+	var walk func(n *node)
+	walk = func(n *node) {
+		expected = append(expected, n.items...)
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(tree.root.Load())
+
+	var items []Item
+	tree.IterateItemsDFS(func(item Item) bool {
+		items = append(items, item)
+		return false
+	})
+	assert.Equal(t, expected, items)
+
+	cnt := 0
+	tree.IterateItemsDFS(func(item Item) bool {
+		cnt++
+		return cnt == 10
+	})
+	assert.Equal(t, 10, cnt)
+}
+
+func TestLeafDepthRange(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	for _, item := range items[:700] {
+		tree.Remove(item, nil)
+	}
+	min, max := tree.LeafDepthRange()
+	assert.Equal(t, tree.Height(), min)
+	assert.Equal(t, tree.Height(), max)
+
+	min, max = New().LeafDepthRange()
+	assert.Equal(t, 1, min)
+	assert.Equal(t, 1, max)
+
+	// malformed: leaf directly below the root
+	root := tree.root.Load()
+	root.children = append(root.children, newNode())
+	min, max = tree.LeafDepthRange()
+	assert.Equal(t, 2, min)
+	assert.Equal(t, tree.Height(), max)
+}
+
+func TestSearchBatch(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	areas := make([]vmath.Rectf, 50)
+	for i := range areas {
+		areas[i] = randomRect()
+	}
+
+	for _, workers := range []int{0, 1, 3} {
+		results := tree.SearchBatch(areas, false, workers)
+		assert.Len(t, results, len(areas))
+		for i, area := range areas {
+			assert.ElementsMatch(t, tree.Search(area, false), results[i])
+		}
+	}
+}
+
+func TestBoundsOK(t *testing.T) {
+	tree, items := newPrePopulatedTree(100)
+	bounds, ok := tree.BoundsOK()
+	assert.True(t, ok)
+	assert.Equal(t, tree.Bounds(), bounds)
+
+	for _, item := range items {
+		tree.MarkRemoved(item, nil)
+	}
+	bounds, ok = tree.BoundsOK()
+	assert.False(t, ok)
+	assert.Equal(t, vmath.Rectf{}, bounds)
+
+	bounds, ok = New().BoundsOK()
+	assert.False(t, ok)
+	assert.Equal(t, vmath.Rectf{}, bounds)
+}
+
+func TestSearchOutside(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{10, 10},
+		Max: vmath.Vec2f{60, 40},
+	}
+
+	var expected []Item
+	for _, item := range items {
+		if !area.ContainsRectf(item.Bounds()) {
+			expected = append(expected, item)
+		}
+	}
+	assert.ElementsMatch(t, expected, tree.SearchOutside(area))
+	assert.Empty(t, New().SearchOutside(area))
+}
+
+func TestNodeBoundsAtLevel(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+
+	for height := 1; height <= tree.Height(); height++ {
+		var expected []vmath.Rectf
+		tree.IterateInternalNodes(func(bounds vmath.Rectf, h int, leaf bool) bool {
+			if h == height {
+				expected = append(expected, bounds)
+			}
+			return false
+		})
+		assert.ElementsMatch(t, expected, tree.NodeBoundsAtLevel(height))
+	}
+	assert.Equal(t, []vmath.Rectf{tree.Bounds()}, tree.NodeBoundsAtLevel(tree.Height()))
+	assert.Empty(t, tree.NodeBoundsAtLevel(0))
+	assert.Empty(t, tree.NodeBoundsAtLevel(tree.Height()+1))
+}
+
+func TestSearchExactPos(t *testing.T) {
+	tree := New()
+	pos := vmath.Vec2f{3, 4}
+	point := &testItem{bounds: vmath.Rectf{Min: pos, Max: pos}}
+	otherPoint := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{3, 4.0001}, Max: vmath.Vec2f{3, 4.0001}}}
+	edge := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{3, 0}, Max: vmath.Vec2f{3, 4}}}
+	area := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{10, 10}}}
+	tree.Insert(point).Insert(point).Insert(otherPoint).Insert(edge).Insert(area)
+
+	assert.Equal(t, []Item{point, point}, tree.SearchExactPos(pos))
+	assert.Len(t, tree.SearchPos(pos), 4)
+	assert.Empty(t, tree.SearchExactPos(vmath.Vec2f{5, 5}))
+}
+
+func TestSearchMinOverlap(t *testing.T) {
+	tree := New()
+	half := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{-2, 0}, Max: vmath.Vec2f{2, 2}}}
+	corner := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{9, 9}, Max: vmath.Vec2f{11, 11}}}
+	covered := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{4, 4}, Max: vmath.Vec2f{5, 5}}}
+	point := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{3, 3}, Max: vmath.Vec2f{3, 3}}}
+	outsidePoint := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{-3, 3}, Max: vmath.Vec2f{-3, 3}}}
+	tree.Insert(half).Insert(corner).Insert(covered).Insert(point).Insert(outsidePoint)
+
+	area := vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{10, 10}}
+	assert.ElementsMatch(t, []Item{half, covered, point}, tree.SearchMinOverlap(area, 0.5))
+	assert.ElementsMatch(t, []Item{covered, point}, tree.SearchMinOverlap(area, 0.6))
+	assert.ElementsMatch(t, []Item{half, corner, covered, point}, tree.SearchMinOverlap(area, 0))
+}
+
+func TestBestOverlap(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{10, 10},
+		Max: vmath.Vec2f{20, 15},
+	}
+
+	expected := float32(0)
+	for _, item := range items {
+		expected = math32.Max(expected, overlapArea(item.Bounds(), area))
+	}
+	best, overlap, ok := tree.BestOverlap(area)
+	assert.True(t, ok)
+	assert.Equal(t, expected, overlap)
+	assert.Equal(t, expected, overlapArea(best.Bounds(), area))
+
+	_, _, ok = tree.BestOverlap(vmath.Rectf{Min: vmath.Vec2f{200, 200}, Max: vmath.Vec2f{300, 300}})
+	assert.False(t, ok)
+}
+
+func TestSearchPosOrNearest(t *testing.T) {
+	tree := New()
+	a := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{2, 2}}}
+	b := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{5, 0}, Max: vmath.Vec2f{6, 2}}}
+	assert.Nil(t, tree.SearchPosOrNearest(vmath.Vec2f{1, 1}))
+
+	tree.Insert(a).Insert(b)
+	assert.Equal(t, a, tree.SearchPosOrNearest(vmath.Vec2f{1, 1}))
+	assert.Equal(t, b, tree.SearchPosOrNearest(vmath.Vec2f{5.5, 1}))
+	assert.Equal(t, b, tree.SearchPosOrNearest(vmath.Vec2f{4, 1}))
+	assert.Equal(t, a, tree.SearchPosOrNearest(vmath.Vec2f{-4, 10}))
+}
+
+func TestSizeHistogram(t *testing.T) {
+	tree := New()
+	for i := 1; i <= 10; i++ {
+		size := float32(i)
+		tree.Insert(&testItem{bounds: vmath.Rectf{Max: vmath.Vec2f{size, 1}}}) // area 1..10
+	}
+
+	histogram, min, max := tree.SizeHistogram(3)
+	assert.Equal(t, []int{3, 3, 4}, histogram)
+	assert.Equal(t, float32(1), min)
+	assert.Equal(t, float32(10), max)
+
+	histogram, min, max = New().SizeHistogram(3)
+	assert.Equal(t, []int{0, 0, 0}, histogram)
+	assert.Equal(t, float32(0), min)
+	assert.Equal(t, float32(0), max)
+}
+
+func TestMightContain(t *testing.T) {
+	tree := New()
+	area := vmath.Rectf{Min: vmath.Vec2f{-1e30, -1e30}, Max: vmath.Vec2f{1e30, 1e30}}
+	assert.False(t, tree.MightContain(area))
+
+	tree.Insert(&testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{10, 10}}})
+	assert.True(t, tree.MightContain(area))
+	assert.True(t, tree.MightContain(vmath.Rectf{Min: vmath.Vec2f{10, 10}, Max: vmath.Vec2f{20, 20}}))
+	assert.False(t, tree.MightContain(vmath.Rectf{Min: vmath.Vec2f{11, 0}, Max: vmath.Vec2f{20, 20}}))
+}
+
+func TestAllWithin(t *testing.T) {
+	tree := New()
+	area := vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{10, 10}}
+	assert.True(t, tree.AllWithin(area))
+
+	tree.Insert(&testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{5, 5}}})
+	tree.Insert(&testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{5, 5}, Max: vmath.Vec2f{10, 10}}})
+	assert.True(t, tree.AllWithin(area))
+	assert.True(t, tree.AllWithin(vmath.Rectf{Min: area.Max, Max: area.Min}))
+	assert.False(t, tree.AllWithin(vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{9, 10}}))
+}
+
+func TestMostRedundantItem(t *testing.T) {
+	tree := New()
+	outer := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{10, 10}}}
+	inner := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{2, 2}, Max: vmath.Vec2f{3, 3}}}
+	edge := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{9, 0}, Max: vmath.Vec2f{12, 1}}}
+	tree.Insert(outer).Insert(edge).Insert(inner)
+
+	item, ok := tree.MostRedundantItem()
+	assert.True(t, ok)
+	assert.Equal(t, inner, item)
+
+	tree.Remove(inner, nil)
+	item, ok = tree.MostRedundantItem()
+	assert.True(t, ok)
+	assert.Equal(t, edge, item)
+
+	_, ok = New().MostRedundantItem()
+	assert.False(t, ok)
+}
+
+func TestAreaPerLevel(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+
+	areas := tree.AreaPerLevel()
+	assert.Len(t, areas, tree.Height()+1)
+	assert.Equal(t, float32(0), areas[0])
+	assert.Equal(t, tree.Bounds().Area(), areas[tree.Height()])
+	for height := 1; height <= tree.Height(); height++ {
+		sum := float32(0)
+		for _, bounds := range tree.NodeBoundsAtLevel(height) {
+			sum += bounds.Area()
+		}
+		assert.InDelta(t, sum, areas[height], float64(sum)*1e-4)
+	}
+
+	assert.Equal(t, []float32{0, 0}, New().AreaPerLevel())
+}
+
+func TestIsReachable(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	for _, item := range items {
+		assert.True(t, tree.IsReachable(item, nil))
+	}
+	assert.False(t, tree.IsReachable(randomItem(), nil))
+
+	copied := *items[0].(*testItem)
+	assert.False(t, tree.IsReachable(&copied, nil))
+	assert.True(t, tree.IsReachable(&copied, func(a, b Item) bool {
+		return a.Bounds() == b.Bounds()
+	}))
+}
+
+func TestEstimateCount(t *testing.T) {
+	tree, _ := newPrePopulatedTree(10000)
+
+	assert.Equal(t, 10000, tree.EstimateCount(tree.Bounds()))
+
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{10, 10},
+		Max: vmath.Vec2f{60, 40},
+	}
+	exact := len(tree.Search(area, false))
+	assert.InDelta(t, exact, tree.EstimateCount(area), float64(exact))
+
+	assert.Equal(t, 0, tree.EstimateCount(vmath.Rectf{Min: vmath.Vec2f{200, 200}, Max: vmath.Vec2f{300, 300}}))
+	assert.Equal(t, 0, New().EstimateCount(area))
+
+	// in deep trees, most items are extrapolated from samples
+	deep := NewConf(4).BulkLoad(randomItems(20000))
+	assert.Equal(t, 20000, deep.EstimateCount(deep.Bounds()))
+	for _, area := range []vmath.Rectf{
+		{Min: vmath.Vec2f{40, 40}, Max: vmath.Vec2f{60, 60}},
+		{Min: vmath.Vec2f{10, 10}, Max: vmath.Vec2f{60, 40}},
+		{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{10, 10}},
+		{Min: vmath.Vec2f{50, 50}, Max: vmath.Vec2f{50.5, 50.5}},
+	} {
+		exact := len(deep.Search(area, false))
+		assert.InEpsilon(t, exact, deep.EstimateCount(area), 0.3, "area %v", area)
+	}
+
+	small, items := newPrePopulatedTree(10)
+	assert.Equal(t, len(items), small.EstimateCount(small.Bounds()))
+}
+
+func TestContentHash(t *testing.T) {
+	hash := func(item Item) uint64 {
+		b := item.Bounds()
+		return uint64(math.Float32bits(b.Min[0]))*31 + uint64(math.Float32bits(b.Min[1]))*17 +
+			uint64(math.Float32bits(b.Max[0]))*13 + uint64(math.Float32bits(b.Max[1]))
+	}
+
+	tree, items := newPrePopulatedTree(1000)
+	other := NewConf(4)
+	for i := len(items) - 1; i >= 0; i-- {
+		other.Insert(items[i])
+	}
+	assert.Equal(t, tree.ContentHash(hash), other.ContentHash(hash))
+
+	other.Remove(items[0], nil)
+	assert.NotEqual(t, tree.ContentHash(hash), other.ContentHash(hash))
+
+	assert.Equal(t, uint64(0), New().ContentHash(hash))
+}
+
+func TestSearchGrouped(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{20, 20},
+		Max: vmath.Vec2f{60, 60},
+	}
+	key := func(item Item) string {
+		if item.Bounds().Min[0] < 40 {
+			return "left"
+		}
+		return "right"
+	}
+
+	groups := tree.SearchGrouped(area, false, key)
+	total := 0
+	for k, items := range groups {
+		for _, item := range items {
+			assert.Equal(t, k, key(item))
+		}
+		total += len(items)
+	}
+	assert.Equal(t, len(tree.Search(area, false)), total)
+	assert.Len(t, groups, 2)
+
+	assert.Empty(t, New().SearchGrouped(area, false, key))
+}
+
+func TestSearchTrace(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{20, 20},
+		Max: vmath.Vec2f{40, 40},
+	}
+
+	visited, descended, leaves := 0, 0, 0
+	tree.SearchTrace(area, func(bounds vmath.Rectf, height int, intersected bool) {
+		visited++
+		assert.Equal(t, area.Intersects(bounds), intersected)
+		if intersected {
+			descended++
+			if height == 1 {
+				leaves++
+			}
+		}
+	})
+	assert.Greater(t, visited, descended)
+	assert.Greater(t, leaves, 0)
+
+	// every match is stored in a descended leaf
+	maxPerLeaf := tree.maxEntries
+	assert.LessOrEqual(t, len(tree.Search(area, false)), leaves*maxPerLeaf)
+}
+
+func TestSearchStats(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{20, 20},
+		Max: vmath.Vec2f{60, 60},
+	}
+
+	items, stats := tree.SearchStats(area, false)
+	assert.ElementsMatch(t, tree.Search(area, false), items)
+	assert.Equal(t, len(items), stats.Results)
+	assert.Greater(t, stats.NodesVisited, 1)
+	assert.Greater(t, stats.ItemsTested, 0)
+
+	items, stats = tree.SearchStats(tree.Bounds(), false)
+	assert.Len(t, items, 1000)
+	assert.Equal(t, 0, stats.ItemsTested) // all children are covered by the search area
+
+	items, stats = tree.SearchStats(vmath.Rectf{Min: vmath.Vec2f{-10, -10}, Max: vmath.Vec2f{-5, -5}}, false)
+	assert.Empty(t, items)
+	assert.Equal(t, QueryStats{}, stats)
+}
+
+func TestSearchReduce(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{20, 20},
+		Max: vmath.Vec2f{60, 60},
+	}
+	expected := tree.Search(area, true)
+
+	count := SearchReduce(tree, area, true, 0, func(cnt int, item Item) int {
+		return cnt + 1
+	})
+	assert.Equal(t, len(expected), count)
+
+	buf := make([]Item, 0, 1000)
+	collected := SearchReduce(tree, area, true, buf[:0], func(items []Item, item Item) []Item {
+		return append(items, item)
+	})
+	assert.ElementsMatch(t, expected, collected)
+	assert.Same(t, &buf[:1][0], &collected[0]) // the buffer was reused
+
+	maxX := SearchReduce(New(), area, false, float32(-1), func(max float32, item Item) float32 {
+		return math32.Max(max, item.Bounds().Max[0])
+	})
+	assert.Equal(t, float32(-1), maxX)
+}
+
+func TestIterateLeaves(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	tree.MarkRemoved(items[0], nil)
+
+	var dfsItems []Item
+	tree.IterateItemsDFS(func(item Item) bool {
+		dfsItems = append(dfsItems, item)
+		return false
+	})
+
+	var leafItems []Item
+	tree.IterateLeaves(func(bounds vmath.Rectf, items []Item) bool {
+		assert.NotEmpty(t, items)
+		for _, item := range items {
+			assert.True(t, bounds.ContainsRectf(item.Bounds()))
+		}
+		leafItems = append(leafItems, items...)
+		return false
+	})
+	assert.Equal(t, dfsItems, leafItems)
+	assert.NotContains(t, leafItems, items[0])
+
+	cnt := 0
+	tree.IterateLeaves(func(bounds vmath.Rectf, items []Item) bool {
+		cnt++
+		return true
+	})
+	assert.Equal(t, 1, cnt)
+
+	New().IterateLeaves(func(bounds vmath.Rectf, items []Item) bool {
+		assert.Fail(t, "empty trees have no leaves with items")
+		return false
+	})
+}
+
+func TestLoadFactor(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	assert.Greater(t, tree.LoadFactor(), float32(0.8))
+	assert.LessOrEqual(t, tree.LoadFactor(), float32(1))
+
+	for _, item := range items[:900] {
+		tree.MarkRemoved(item, nil)
+	}
+	assert.Less(t, tree.LoadFactor(), float32(0.2))
+
+	assert.Equal(t, float32(0), New().LoadFactor())
+}
+
+func TestSearchZOrder(t *testing.T) {
+	assert.Equal(t, uint64(0b1001), mortonCode(0b01, 0b10))
+	assert.Equal(t, uint64(math.MaxUint64), mortonCode(math.MaxUint32, math.MaxUint32))
+
+	tree := New()
+	area := vmath.Rectf{Max: vmath.Vec2f{4, 4}}
+	// 2x2 grid of cells; Z-order visits bottom-left, bottom-right, top-left, top-right
+	expected := []Item{
+		&testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{0, 0}, Max: vmath.Vec2f{1, 1}}},
+		&testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{3, 0}, Max: vmath.Vec2f{4, 1}}},
+		&testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{0, 3}, Max: vmath.Vec2f{1, 4}}},
+		&testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{3, 3}, Max: vmath.Vec2f{4, 4}}},
+	}
+	for i := len(expected) - 1; i >= 0; i-- {
+		tree.Insert(expected[i])
+	}
+	assert.Equal(t, expected, tree.SearchZOrder(area, true, 1))
+	assert.Equal(t, expected, tree.SearchZOrder(area, true, 0))
+	assert.Equal(t, expected, tree.SearchZOrder(area, true, 64))
+
+	big, _ := newPrePopulatedTree(1000)
+	area = vmath.Rectf{Min: vmath.Vec2f{20, 20}, Max: vmath.Vec2f{60, 60}}
+	assert.ElementsMatch(t, big.Search(area, false), big.SearchZOrder(area, false, 8))
+}
+
+func TestSearchPosInclusive(t *testing.T) {
+	tree := New()
+	var tiles []Item
+	for x := float32(0); x < 10; x++ {
+		for y := float32(0); y < 10; y++ {
+			tile := &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{x, y}, Max: vmath.Vec2f{x + 1, y + 1}}}
+			tiles = append(tiles, tile)
+			tree.Insert(tile)
+		}
+	}
+
+	assert.Len(t, tree.SearchPosInclusive(vmath.Vec2f{2.5, 2.5}), 1)
+	assert.Len(t, tree.SearchPosInclusive(vmath.Vec2f{3, 2.5}), 2)    // shared edge
+	assert.Len(t, tree.SearchPosInclusive(vmath.Vec2f{3, 3}), 4)      // shared corner
+	assert.Len(t, tree.SearchPosInclusive(vmath.Vec2f{10, 10}), 1)    // outer corner
+	assert.Empty(t, tree.SearchPosInclusive(vmath.Vec2f{10.001, 10})) // outside
+	assert.ElementsMatch(t, tree.SearchPos(vmath.Vec2f{3, 3}), tree.SearchPosInclusive(vmath.Vec2f{3, 3}))
+}
+
+func TestSearchHalfPlane(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+
+	for axis := 0; axis < 2; axis++ {
+		var lower, upper []Item
+		for _, item := range items {
+			if item.Bounds().Max[axis] <= 40 {
+				lower = append(lower, item)
+			}
+			if item.Bounds().Min[axis] >= 40 {
+				upper = append(upper, item)
+			}
+		}
+		assert.ElementsMatch(t, lower, tree.SearchHalfPlane(axis, 40, true))
+		assert.ElementsMatch(t, upper, tree.SearchHalfPlane(axis, 40, false))
+	}
+	assert.Len(t, tree.SearchHalfPlane(0, 1000, true), 1000)
+	assert.Empty(t, tree.SearchHalfPlane(1, 1000, false))
+
+	assert.PanicsWithValue(t, "rtree: axis must be 0 or 1", func() {
+		tree.SearchHalfPlane(2, 0, true)
+	})
+}
+
+func TestIntersectsBatch(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	areas := []vmath.Rectf{
+		{Min: vmath.Vec2f{20, 20}, Max: vmath.Vec2f{30, 30}},
+		{Min: vmath.Vec2f{-20, -20}, Max: vmath.Vec2f{-10, -10}},
+		{Min: vmath.Vec2f{60, 60}, Max: vmath.Vec2f{50, 50}},
+		{Min: vmath.Vec2f{1000, 0}, Max: vmath.Vec2f{2000, 100}},
+	}
+
+	results := tree.IntersectsBatch(areas, 2)
+	assert.Len(t, results, len(areas))
+	for i, area := range areas {
+		assert.Equal(t, len(tree.Search(area, false)) > 0, results[i])
+	}
+	assert.Equal(t, []bool{true, false, true, false}, results)
+
+	assert.Equal(t, []bool{false}, New().IntersectsBatch(areas[:1], 0))
+}
@@ -12,11 +12,13 @@ import (
 // like "github.com/keegancsmith/nth" and "github.com/wangjohn/quickselect".
 // It also provided better performance than a custom implementation using the Floyd-Rivest selection algorithm
 // which is explained here: https://en.wikipedia.org/wiki/Floyd%E2%80%93Rivest_algorithm
-func quickselect(a sort.Interface, n int) {
+//
+// Pivots are chosen randomly from the given source. If it is nil, the global source is used.
+func quickselect(a sort.Interface, n int, rnd *rand.Rand) {
 	first := 0
 	last := a.Len() - 1
 	for {
-		guess := rand.Intn(last-first+1) + first
+		guess := randIntn(rnd, last-first+1) + first
 		pivotIndex := partition(a, first, last, guess)
 		if n == pivotIndex { // found nth element
 			return
@@ -28,6 +30,23 @@ func quickselect(a sort.Interface, n int) {
 	}
 }
 
+// randIntn returns a random number in [0, n) from the given source, or from the global source if it is nil.
+func randIntn(rnd *rand.Rand, n int) int {
+	if rnd == nil {
+		return rand.Intn(n)
+	}
+	return rnd.Intn(n)
+}
+
+// deriveRand returns a new random source that is seeded from the given one.
+// Returns nil if the given source is nil.
+func deriveRand(rnd *rand.Rand) *rand.Rand {
+	if rnd == nil {
+		return nil
+	}
+	return rand.New(rand.NewSource(rnd.Int63()))
+}
+
 // partition moves all elements smaller than the pivot to its left, and all bigger values to its right.
 // Returns the new position of the pivot.
 func partition(a sort.Interface, firstIdx, lastIdx, pivotIdx int) int {
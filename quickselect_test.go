@@ -13,7 +13,7 @@ import (
 func TestQuickSelect(t *testing.T) {
 	arr := []int{65, 28, 59, 52, 21, 56, 22, 95, 50, 12, 90, 53, 28, 54, 39}
 	pivot := 8
-	quickselect(sort.IntSlice(arr), pivot)
+	quickselect(sort.IntSlice(arr), pivot, nil)
 	assertQuickSelectResult(t, arr, pivot)
 }
 
@@ -31,7 +31,7 @@ func TestQuickSelect_BruteForce(t *testing.T) {
 			}
 
 			pivot := rand.Intn(testSize)
-			quickselect(sort.IntSlice(arr), pivot)
+			quickselect(sort.IntSlice(arr), pivot, nil)
 
 			if !assertQuickSelectResult(t, arr, pivot) {
 				t.Logf("Pivot: %d (=%d), Data: %v", pivot, arr[pivot], arr)
@@ -62,7 +62,7 @@ func BenchmarkQuickSelect(b *testing.B) {
 		b.StopTimer()
 		arr := makeTestData()
 		b.StartTimer()
-		quickselect(arr, arr.Len()/2)
+		quickselect(arr, arr.Len()/2, nil)
 	}
 }
 
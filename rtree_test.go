@@ -1,10 +1,12 @@
 package rtree
 
 import (
+	"fmt"
 	"math/rand"
 	"testing"
 
 	"github.com/maja42/vmath"
+	"github.com/stretchr/testify/assert"
 )
 
 const testTreeSize = 10000
@@ -18,6 +20,717 @@ func (i *testItem) Bounds() vmath.Rectf {
 	return i.bounds
 }
 
+func TestBulkLoadResult(t *testing.T) {
+	tree := NewConf(10)
+	assert.False(t, tree.BulkLoadResult(randomItems(tree.minEntries-1)))
+	assert.True(t, tree.BulkLoadResult(randomItems(tree.minEntries)))
+	assert.Equal(t, 2*tree.minEntries-1, tree.Size())
+
+	assert.False(t, tree.BulkLoadResult(nil))
+}
+
+func TestNewSized(t *testing.T) {
+	items := randomItems(1000)
+	var sized *RTree
+	sizedAllocs := testing.AllocsPerRun(1, func() {
+		sized = NewSized(16, len(items))
+		for _, item := range items {
+			sized.Insert(item)
+		}
+	})
+	allocs := testing.AllocsPerRun(1, func() {
+		tree := NewConf(16)
+		for _, item := range items {
+			tree.Insert(item)
+		}
+	})
+	assert.Less(t, sizedAllocs, allocs)
+
+	assert.ElementsMatch(t, items, sized.All())
+	assert.Empty(t, sized.Search(vmath.Rectf{Min: vmath.Vec2f{-10, -10}, Max: vmath.Vec2f{-5, -5}}, false))
+
+	assert.Equal(t, 0, NewSized(16, 0).Size())
+	assert.NotPanics(t, func() {
+		NewSized(16, -1000).Insert(items[0])
+	})
+}
+
+func TestBulkLoadDedup(t *testing.T) {
+	items := randomItems(100)
+	input := append([]Item{}, items...)
+	copies := make([]Item, 0, 20)
+	for _, item := range items[:20] {
+		cpy := &testItem{data: make([]byte, 4096), bounds: item.Bounds()} // randomItem never creates this much data
+		copies = append(copies, cpy)
+	}
+	input = append(input, copies...)
+	input = append(input, items[:10]...)
+
+	tree := New()
+	assert.Equal(t, 30, tree.BulkLoadDedup(input, nil))
+	assert.ElementsMatch(t, items, tree.All())
+
+	// items with equal bounds are only dropped if equalsFn matches
+	sameData := func(a, b Item) bool {
+		return len(a.(*testItem).data) == len(b.(*testItem).data)
+	}
+	tree = New()
+	assert.Equal(t, 10, tree.BulkLoadDedup(input, sameData))
+	assert.ElementsMatch(t, append(append([]Item{}, items...), copies...), tree.All())
+
+	assert.Equal(t, 0, New().BulkLoadDedup(nil, nil))
+}
+
+func TestBulkLoad_Empty(t *testing.T) {
+	tree, _ := newPrePopulatedTree(100)
+	root, version := tree.root.Load(), tree.version.Load()
+
+	assert.Same(t, tree, tree.BulkLoad(nil))
+	assert.Same(t, tree, tree.BulkLoad([]Item{}))
+	assert.Same(t, root, tree.root.Load())
+	assert.Equal(t, version, tree.version.Load())
+	assert.Equal(t, 100, tree.Size())
+}
+
+func TestInsert_Nil(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	assert.Greater(t, tree.Height(), 2)
+
+	inserts := map[string]func(){
+		"Insert":            func() { tree.Insert(nil) },
+		"InsertLazy":        func() { New().SetLazy(true).Insert(nil) },
+		"InsertWithBounds":  func() { tree.InsertWithBounds(nil, vmath.Rectf{}) },
+		"InsertResult":      func() { tree.InsertResult(nil) },
+		"InsertTracked":     func() { tree.InsertTracked(nil) },
+		"InsertBatch":       func() { tree.InsertBatch([]Item{nil}) },
+		"InsertAtLevel":     func() { tree.InsertAtLevel(nil, 1) },
+		"InsertAtLeafLevel": func() { tree.InsertAtLevel(nil, tree.Height()-1) },
+	}
+	for name, insert := range inserts {
+		assert.PanicsWithValue(t, "rtree: cannot insert nil item", insert, name)
+	}
+	assert.Equal(t, 1000, tree.Size())
+	assert.Len(t, tree.Search(tree.Bounds(), false), 1000)
+}
+
+func TestBulkLoad_ConcurrentReaders(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	area := vmath.Rectf{Max: vmath.Vec2f{100, 100}}
+
+	version := tree.Version()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			tree.BulkLoad(randomItems(100))
+			tree.BulkLoad(randomItems(2))
+		}
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		cnt := len(tree.Search(area, false))
+		assert.Zero(t, (cnt-1000)%102%100, "observed a partially loaded tree with %d items", cnt)
+		assert.GreaterOrEqual(t, tree.Version(), version) // run with -race
+	}
+	assert.Equal(t, 1000+20*102, tree.Size())
+	assert.Equal(t, version+40, tree.Version())
+}
+
+func TestSetStableBuild(t *testing.T) {
+	rects := make([]vmath.Rectf, 10)
+	for i := range rects {
+		rects[i] = randomRect().Normalize()
+	}
+	items := make([]Item, 1000)
+	for i := range items {
+		items[i] = &testItem{bounds: rects[rand.Intn(len(rects))]}
+	}
+	input := append([]Item(nil), items...)
+
+	tree := New().SetStableBuild(true)
+	tree.BulkLoad(items)
+
+	// items with equal bounds are stored in input order
+	var stored []Item
+	tree.IterateItemsDFS(func(item Item) bool {
+		stored = append(stored, item)
+		return false
+	})
+	for _, rect := range rects {
+		var expected, actual []Item
+		for _, item := range input {
+			if item.Bounds() == rect {
+				expected = append(expected, item)
+			}
+		}
+		for _, item := range stored {
+			if item.Bounds() == rect {
+				actual = append(actual, item)
+			}
+		}
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestSetRandSource(t *testing.T) {
+	items := randomItems(5000)
+	dfsOrder := func(tree *RTree) []Item {
+		var order []Item
+		tree.IterateItemsDFS(func(item Item) bool {
+			order = append(order, item)
+			return false
+		})
+		return order
+	}
+
+	a := New().SetRandSource(rand.New(rand.NewSource(42)))
+	a.BulkLoad(append([]Item(nil), items...))
+	b := New().SetRandSource(rand.New(rand.NewSource(42)))
+	b.BulkLoad(append([]Item(nil), items...))
+	assert.Equal(t, dfsOrder(a), dfsOrder(b))
+}
+
+func TestInsert_SplitBounds(t *testing.T) {
+	// Regression test: nodes that are split during an insertion must not be extended by the new item afterwards,
+	// since the item might have been moved into the new sibling.
+	tree := NewConf(4)
+	for _, item := range randomItems(1000) {
+		tree.Insert(item)
+	}
+	assertTreeBounds(t, tree)
+}
+
+func TestInsertWithBounds(t *testing.T) {
+	tree, items := newPrePopulatedTree(100)
+	for i := 0; i < 100; i++ {
+		item := randomItem()
+		tree.InsertWithBounds(item, item.Bounds())
+		items = append(items, item)
+	}
+	assert.ElementsMatch(t, items, tree.All())
+	for _, item := range items {
+		assert.True(t, tree.IsReachable(item, nil))
+	}
+}
+
+func TestInsertResult(t *testing.T) {
+	tree := New()
+	grown := 0
+	for i := 0; i < 1000; i++ {
+		height := tree.Height()
+		grew := tree.InsertResult(randomItem())
+		assert.Equal(t, tree.Height() > height, grew)
+		if grew {
+			grown++
+		}
+	}
+	assert.Equal(t, tree.Height()-1, grown)
+}
+
+func TestSetOverlapMinimization(t *testing.T) {
+	items := clusteredItems(rand.New(rand.NewSource(1)), 5000)
+	tree := New().SetOverlapMinimization(true)
+	areaOnly := New()
+	for _, item := range items {
+		tree.Insert(item)
+		areaOnly.Insert(item)
+	}
+	assert.ElementsMatch(t, items, tree.All())
+	for _, item := range items[:100] {
+		assert.Contains(t, tree.Search(item.Bounds(), true), item)
+	}
+	assertTreeBounds(t, tree)
+	min, max := tree.LeafDepthRange()
+	assert.Equal(t, min, max)
+	assert.Less(t, overlapScore(tree.root.Load()), overlapScore(areaOnly.root.Load()))
+}
+
+func TestRemoveFarthestItems(t *testing.T) {
+	item := func(x float32) Item {
+		return &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{x, 0}, Max: vmath.Vec2f{x + 1, 1}}}
+	}
+	items := []Item{item(0), item(9), item(5), item(4), item(1)}
+	leaf := &node{leaf: true, height: 1, items: append([]Item(nil), items...)}
+	calcBBox(leaf)
+
+	removed := removeFarthestItems(leaf, 2)
+	assert.Equal(t, []Item{items[2], items[3], items[4]}, leaf.items)
+	assert.Equal(t, []Item{items[0], items[1]}, removed)
+}
+
+func TestChooseLeafByOverlap(t *testing.T) {
+	leaf := func(minX, minY, maxX, maxY float32) *node {
+		return &node{leaf: true, height: 1, bounds: vmath.Rectf{Min: vmath.Vec2f{minX, minY}, Max: vmath.Vec2f{maxX, maxY}}}
+	}
+	a := leaf(0, 0, 10, 10)
+	b := leaf(20, 0, 21, 1)
+	c := leaf(17, 0, 18, 10)
+	bbox := vmath.Rectf{Min: vmath.Vec2f{15, 0}, Max: vmath.Vec2f{16, 1}}
+
+	// b needs the least area enlargement, but would then overlap with c
+	root := &node{height: 2, children: []*node{a, b, c}}
+	chosen, _ := New().chooseSubtree(bbox, root, 1)
+	assert.Same(t, b, chosen)
+	chosen, _ = New().SetOverlapMinimization(true).chooseSubtree(bbox, root, 1)
+	assert.Same(t, c, chosen) // a and c don't overlap afterwards; c needs less enlargement
+
+	// leaves that need a lot of enlargement are not considered, even if the overlap wouldn't increase
+	d := leaf(50, 50, 51, 51)
+	root.children = append(root.children, leaf(14, 0, 15, 1), d)
+	chosen, _ = New().SetOverlapMinimization(true).chooseSubtree(vmath.Rectf{Min: vmath.Vec2f{40, 40}, Max: vmath.Vec2f{41, 41}}, root, 1)
+	assert.Same(t, d, chosen)
+}
+
+func TestSetCostFunc(t *testing.T) {
+	items := elongatedItems(rand.New(rand.NewSource(1)), 2000)
+	tree := New().SetCostFunc(MarginCost)
+	for _, item := range items {
+		tree.Insert(item)
+	}
+	assert.ElementsMatch(t, items, tree.All())
+	for _, item := range items[:100] {
+		assert.Contains(t, tree.Search(item.Bounds(), true), item)
+	}
+
+	// the default cost function produces the same tree as AreaCost
+	a, b := New(), New().SetCostFunc(AreaCost)
+	for _, item := range items {
+		a.Insert(item)
+		b.Insert(item)
+	}
+	assert.Equal(t, a.root.Load(), b.root.Load())
+	assert.NotEqual(t, a.root.Load(), tree.root.Load())
+}
+
+func TestChooseSplitIndex(t *testing.T) {
+	item := func(minX, minY, maxX, maxY float32) Item {
+		return &testItem{bounds: vmath.Rectf{Min: vmath.Vec2f{minX, minY}, Max: vmath.Vec2f{maxX, maxY}}}
+	}
+	leaf := &node{leaf: true, height: 1, items: []Item{
+		item(0, 0, 2, 10),
+		item(1, 0, 3, 1),
+		item(4, 0, 5, 1),
+	}}
+
+	// splitting after the first item has the lower total area, but the resulting nodes overlap
+	assert.Equal(t, 2, New().chooseSplitIndex(leaf, 1, 3))
+	assert.Equal(t, 2, New().SetCostFunc(MarginCost).chooseSplitIndex(leaf, 1, 3))
+}
+
+func TestInsertBatch(t *testing.T) {
+	tree := NewConf(4)
+	items := randomItems(1000)
+
+	splits := tree.InsertBatch(items)
+	assert.ElementsMatch(t, items, tree.All())
+	// every insertion splits at most one leaf, which creates a single new leaf
+	leaves := len(tree.NodeBoundsAtLevel(1))
+	assert.Equal(t, leaves-1, splits)
+
+	assert.Equal(t, 0, New().InsertBatch(items[:10]))
+}
+
+func TestInsertAtLevel(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	height := tree.Height()
+
+	item := randomItem()
+	tree.InsertAtLevel(item, 1)
+	items = append(items, item)
+	min, max := tree.LeafDepthRange()
+	assert.Equal(t, 2, min)
+	assert.Equal(t, height, max)
+	assert.Contains(t, tree.Search(item.Bounds(), true), item)
+
+	// regular insertions still work on unbalanced trees
+	for i := 0; i < 500; i++ {
+		item := randomItem()
+		tree.Insert(item)
+		items = append(items, item)
+	}
+	assert.ElementsMatch(t, items, tree.All())
+	for _, item := range items {
+		assert.Contains(t, tree.Search(item.Bounds(), true), item)
+	}
+
+	tree.InsertAtLevel(item, tree.Height()-1)
+	assert.Equal(t, len(items)+1, tree.Size())
+
+	assert.Panics(t, func() { tree.InsertAtLevel(item, 0) })
+	assert.Panics(t, func() { tree.InsertAtLevel(item, tree.Height()) })
+	assert.NotPanics(t, func() { New().InsertAtLevel(item, 0) })
+}
+
+func TestInsertAtLevel_BulkLoad(t *testing.T) {
+	tree, _ := newPrePopulatedTree(5000)
+	pos := vmath.Vec2f{500, 500}
+	tree.InsertAtLevel(&testItem{bounds: vmath.Rectf{Min: pos, Max: pos}}, 1)
+	tree.InsertAtLevel(&testItem{bounds: vmath.Rectf{Min: pos, Max: pos.AddScalar(1)}}, 1)
+
+	items := make([]Item, 200)
+	for i := range items {
+		min := pos.Add(vmath.Vec2f{rand.Float32() * 10, rand.Float32() * 10})
+		items[i] = &testItem{bounds: vmath.Rectf{Min: min, Max: min.AddScalar(1)}}
+	}
+	assert.False(t, tree.BulkLoadResult(items))
+
+	nodesToSearch := []*node{tree.root.Load()}
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+		assert.False(t, node.leaf && len(node.children) > 0, "leaf node with children")
+	}
+	for _, item := range items {
+		nearest := tree.NearestNeighbor(item.Bounds().Min)
+		assert.Zero(t, nearest.Bounds().SquarePointDistance(item.Bounds().Min))
+	}
+
+	// repacking restores a balanced tree
+	tree.BulkRepack(nil)
+	min, max := tree.LeafDepthRange()
+	assert.Equal(t, min, max)
+	assert.True(t, tree.BulkLoadResult(randomItems(200)))
+}
+
+func TestNewWithOptions(t *testing.T) {
+	items := randomItems(5000)
+
+	parallel := NewWithOptions(16, true).SetRandSource(rand.New(rand.NewSource(42)))
+	parallel.BulkLoad(append([]Item(nil), items...))
+	sequential := NewWithOptions(16, false).SetRandSource(rand.New(rand.NewSource(42)))
+	sequential.BulkLoad(append([]Item(nil), items...))
+
+	assert.Equal(t, parallel.root.Load(), sequential.root.Load())
+}
+
+func TestBulkInsert(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+
+	small := randomItems(10)
+	tree.BulkInsert(small)
+	items = append(items, small...)
+	assert.ElementsMatch(t, items, tree.All())
+
+	big := randomItems(500)
+	tree.BulkInsert(big)
+	items = append(items, big...)
+	assert.ElementsMatch(t, items, tree.All())
+}
+
+func TestRemoveAllEqual(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	dup := items[42]
+	for i := 0; i < 50; i++ {
+		tree.Insert(dup)
+	}
+
+	removed := tree.RemoveAllEqual(dup, nil)
+	assert.Equal(t, 51, removed)
+	assert.Equal(t, 999, tree.Size())
+	assert.NotContains(t, tree.All(), dup)
+
+	for _, item := range tree.All() {
+		assert.Equal(t, 1, tree.RemoveAllEqual(item, nil))
+	}
+	assert.Equal(t, 0, tree.Size())
+	assert.Equal(t, 1, tree.Height())
+}
+
+func TestRemoveFirstAt(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	bounds := items[42].Bounds()
+	dup := &testItem{bounds: bounds}
+	tree.Insert(dup)
+
+	removed, ok := tree.RemoveFirstAt(bounds)
+	assert.True(t, ok)
+	assert.Contains(t, []Item{items[42], dup}, removed)
+	_, ok = tree.RemoveFirstAt(bounds)
+	assert.True(t, ok)
+	_, ok = tree.RemoveFirstAt(bounds)
+	assert.False(t, ok)
+
+	assert.Equal(t, 999, tree.Size())
+	assert.NotContains(t, tree.All(), items[42])
+}
+
+func TestRemoveIter(t *testing.T) {
+	sliceIter := func(items []Item) func() (Item, bool) {
+		return func() (Item, bool) {
+			if len(items) == 0 {
+				return nil, false
+			}
+			item := items[0]
+			items = items[1:]
+			return item, true
+		}
+	}
+
+	for _, count := range []int{10, 900} { // below and above the rebuild threshold
+		tree, items := newPrePopulatedTree(1000)
+		removed := append(items[:count:count], randomItem()) // unknown item
+		assert.Equal(t, count, tree.RemoveIter(sliceIter(removed), nil))
+		assert.ElementsMatch(t, items[count:], tree.All())
+		assertTreeBounds(t, tree)
+	}
+}
+
+func TestShrinkToFit(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	for _, item := range items[:900] {
+		tree.Remove(item, nil)
+	}
+	tree.ShrinkToFit()
+	assert.ElementsMatch(t, items[900:], tree.All())
+
+	nodesToSearch := []*node{tree.root.Load()}
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+		assert.Equal(t, len(node.children), cap(node.children))
+		assert.Equal(t, len(node.items), cap(node.items))
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1)) // the result depends on the tree structure and needs to be reproducible
+	items := make([]Item, 2000)
+	for i := range items {
+		pos := vmath.Vec2f{rnd.Float32() * 100, rnd.Float32() * 100}
+		items[i] = &testItem{bounds: vmath.Rectf{Min: pos, Max: pos.Add(vmath.Vec2f{3, 3})}}
+	}
+	tree := New().SetRandSource(rnd)
+	for _, item := range items {
+		tree.Insert(item)
+	}
+	for _, item := range items[:500] {
+		tree.MarkRemoved(item, nil)
+	}
+
+	// one-by-one insertion produces more overlap than bulk-loading
+	assert.True(t, tree.SetOptimizeThreshold(1).Optimize())
+	assert.ElementsMatch(t, items[500:], tree.All())
+	assertTreeBounds(t, tree)
+
+	for _, item := range items[500:1000] {
+		tree.Remove(item, nil)
+	}
+	assert.False(t, tree.SetOptimizeThreshold(1e9).Optimize())
+	assert.ElementsMatch(t, items[1000:], tree.All())
+	assertTreeBounds(t, tree)
+
+	assert.False(t, New().Optimize())
+}
+
+func TestSetCondense(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	tree.SetCondense(false)
+	height := tree.Height()
+
+	for _, item := range items[:900] {
+		tree.Remove(item, nil)
+	}
+	kept := items[900:]
+	assert.Equal(t, height, tree.Height())
+	assert.ElementsMatch(t, kept, tree.All())
+	assertTreeBounds(t, tree)
+
+	pos := vmath.Vec2f{50, 50}
+	assert.Equal(t, bruteForceSqDistances(kept, pos)[0], tree.NearestNeighbor(pos).Bounds().SquarePointDistance(pos))
+
+	for _, item := range kept {
+		tree.Remove(item, nil)
+	}
+	assert.Equal(t, 0, tree.Size())
+	assert.Nil(t, tree.NearestNeighbor(pos))
+
+	tree.BulkLoad(items)
+	assert.ElementsMatch(t, items, tree.All())
+	assertTreeBounds(t, tree)
+}
+
+func TestSetCondense_Bulk(t *testing.T) {
+	area := vmath.Rectf{Min: vmath.Vec2f{-1000, -1000}, Max: vmath.Vec2f{1000, 1000}}
+	removals := map[string]func(tree *RTree, items []Item){
+		"ReplaceArea": func(tree *RTree, items []Item) {
+			tree.ReplaceArea(area, false, nil)
+		},
+		"RemoveAllEqual": func(tree *RTree, items []Item) {
+			for _, item := range items {
+				tree.RemoveAllEqual(item, nil)
+			}
+		},
+		"Compact": func(tree *RTree, items []Item) {
+			for _, item := range items {
+				tree.MarkRemoved(item, nil)
+			}
+			tree.Compact()
+		},
+	}
+	for name, remove := range removals {
+		t.Run(name, func(t *testing.T) {
+			tree, items := newPrePopulatedTree(1000)
+			tree.SetCondense(false)
+			height := tree.Height()
+			nodes := countNodes(tree.root.Load())
+
+			remove(tree, items)
+			assert.Equal(t, 0, tree.Size())
+			assert.Equal(t, height, tree.Height())
+			assert.Equal(t, nodes, countNodes(tree.root.Load()))
+
+			tree.Insert(items[0])
+			assert.Equal(t, []Item{items[0]}, tree.All())
+			assertTreeBounds(t, tree)
+		})
+	}
+}
+
+func countNodes(nod *node) int {
+	cnt := 1
+	for _, child := range nod.children {
+		cnt += countNodes(child)
+	}
+	return cnt
+}
+
+func TestClearRetain(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+	root := tree.root.Load()
+
+	tree.ClearRetain()
+	assert.Equal(t, 0, tree.Size())
+	assert.Equal(t, 1, tree.Height())
+	assert.Equal(t, noBounds, tree.Bounds())
+	assert.Same(t, root, tree.root.Load())
+
+	items := randomItems(100)
+	for _, item := range items {
+		tree.Insert(item)
+	}
+	assert.ElementsMatch(t, items, tree.All())
+
+	// bulk-loading reuses the retained root
+	tree, _ = newPrePopulatedTree(1000)
+	root = tree.root.Load()
+	tree.ClearRetain()
+	items = randomItems(1000)
+	tree.BulkLoad(items)
+	assert.Same(t, root, tree.root.Load())
+	assert.ElementsMatch(t, items, tree.All())
+	assertTreeBounds(t, tree)
+
+	tree.Clear()
+	tree.BulkLoad(items)
+	assert.NotSame(t, root, tree.root.Load())
+}
+
+func TestVersion(t *testing.T) {
+	tree := New()
+	item := randomItem()
+
+	v := tree.Version()
+	tree.Insert(item)
+	assert.NotEqual(t, v, tree.Version())
+
+	v = tree.Version()
+	tree.Remove(randomItem(), nil) // not contained
+	assert.Equal(t, v, tree.Version())
+	tree.Remove(item, nil)
+	assert.NotEqual(t, v, tree.Version())
+
+	v = tree.Version()
+	tree.BulkLoad(randomItems(100))
+	assert.NotEqual(t, v, tree.Version())
+
+	v = tree.Version()
+	tree.Clear()
+	assert.NotEqual(t, v, tree.Version())
+}
+
+func TestPartition(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{10, 20},
+		Max: vmath.Vec2f{70, 60},
+	}
+
+	inside, outside := tree.Partition(area)
+	assert.ElementsMatch(t, tree.Search(area, true), inside.All())
+	assert.Equal(t, 1000, inside.Size()+outside.Size())
+	assert.ElementsMatch(t, items, append(inside.All(), outside.All()...))
+	assert.Equal(t, 1000, tree.Size())
+
+	// the configuration is kept
+	tree = NewConf(8).SetParallelBuild(false).SetStableBuild(true).SetSortedLeaves(true).SetCostFunc(MarginCost)
+	inside, _ = tree.BulkLoad(items).Partition(area)
+	assert.Equal(t, 8, inside.maxEntries)
+	assert.True(t, inside.sequentialBuild)
+	assert.True(t, inside.stableBuild)
+	assert.True(t, inside.sortedLeaves)
+	assert.NotNil(t, inside.costFn)
+}
+
+// sharedStateItem reads and modifies shared state when its bounds are requested.
+type sharedStateItem struct {
+	bounds vmath.Rectf
+	calls  *int
+}
+
+func (i *sharedStateItem) Bounds() vmath.Rectf {
+	*i.calls++
+	return i.bounds
+}
+
+func TestSetParallelBuild(t *testing.T) {
+	calls := 0
+	items := make([]Item, 5000)
+	for i := range items {
+		items[i] = &sharedStateItem{
+			bounds: randomRect(),
+			calls:  &calls,
+		}
+	}
+
+	// run with -race to detect concurrent calls to Bounds()
+	tree := New().SetParallelBuild(false)
+	tree.BulkLoad(items)
+	assert.Equal(t, 5000, tree.Size())
+	assert.NotZero(t, calls)
+
+	// trees created from the tree keep the setting
+	inside, outside := tree.Partition(vmath.Rectf{Max: vmath.Vec2f{50, 50}})
+	assert.Equal(t, 5000, inside.Size()+outside.Size())
+}
+
+func TestReplaceArea(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	area := vmath.Rectf{
+		Min: vmath.Vec2f{30, 30},
+		Max: vmath.Vec2f{60, 50},
+	}
+	matching := tree.Search(area, true)
+	newItems := randomItems(200)
+
+	removed := tree.ReplaceArea(area, true, newItems)
+	assert.Equal(t, len(matching), removed)
+
+	var expected []Item
+	for _, item := range items {
+		if !area.ContainsRectf(item.Bounds()) {
+			expected = append(expected, item)
+		}
+	}
+	expected = append(expected, newItems...)
+	assert.ElementsMatch(t, expected, tree.All())
+}
+
 func BenchmarkInsert(b *testing.B) {
 	tree, _ := newPrePopulatedTree(testTreeSize)
 	b.ResetTimer()
@@ -26,6 +739,75 @@ func BenchmarkInsert(b *testing.B) {
 	}
 }
 
+func BenchmarkInsert_SortedLeaves(b *testing.B) {
+	tree, _ := newPrePopulatedTree(testTreeSize)
+	tree.SetSortedLeaves(true)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Insert(randomItem())
+	}
+}
+
+func BenchmarkSearch_Clustered(b *testing.B) {
+	benchmarkSearchClustered(b, New())
+}
+
+func BenchmarkSearch_ClusteredOverlapMinimization(b *testing.B) {
+	benchmarkSearchClustered(b, New().SetOverlapMinimization(true))
+}
+
+func benchmarkSearchClustered(b *testing.B, tree *RTree) {
+	items := clusteredItems(rand.New(rand.NewSource(1)), testTreeSize)
+	for _, item := range items {
+		tree.Insert(item)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		item := items[rand.Intn(len(items))]
+		_ = tree.Search(item.Bounds(), false)
+	}
+}
+
+func BenchmarkSearch_Overlapping(b *testing.B) {
+	benchmarkSearchOverlapping(b, New())
+}
+
+func BenchmarkSearch_OverlappingOverlapMinimization(b *testing.B) {
+	benchmarkSearchOverlapping(b, New().SetOverlapMinimization(true))
+}
+
+func benchmarkSearchOverlapping(b *testing.B, tree *RTree) {
+	items := randomItems(testTreeSize) // large items with a lot of overlap
+	for _, item := range items {
+		tree.Insert(item)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pos := items[rand.Intn(len(items))].Bounds().Min
+		_ = tree.Search(vmath.Rectf{Min: pos, Max: pos.AddScalar(2)}, false)
+	}
+}
+
+func BenchmarkSearch_ElongatedAreaCost(b *testing.B) {
+	benchmarkSearchElongated(b, New())
+}
+
+func BenchmarkSearch_ElongatedMarginCost(b *testing.B) {
+	benchmarkSearchElongated(b, New().SetCostFunc(MarginCost))
+}
+
+func benchmarkSearchElongated(b *testing.B, tree *RTree) {
+	items := elongatedItems(rand.New(rand.NewSource(1)), testTreeSize)
+	for _, item := range items {
+		tree.Insert(item)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pos := vmath.Vec2f{rand.Float32() * 1000, rand.Float32() * 1000}
+		_ = tree.Search(vmath.Rectf{Min: pos, Max: pos.Add(vmath.Vec2f{5, 5})}, false)
+	}
+}
+
 func BenchmarkSearch(b *testing.B) {
 	tree, items := newPrePopulatedTree(testTreeSize)
 	b.ResetTimer()
@@ -54,16 +836,37 @@ func BenchmarkRemove(b *testing.B) {
 	}
 }
 
+func BenchmarkRemove_Churn(b *testing.B) {
+	for _, condense := range []bool{true, false} {
+		b.Run(fmt.Sprintf("condense=%v", condense), func(b *testing.B) {
+			tree, items := newPrePopulatedTree(testTreeSize)
+			tree.SetCondense(condense)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				idx := rand.Intn(len(items))
+				tree.Remove(items[idx], nil)
+				items[idx] = randomItem()
+				tree.Insert(items[idx])
+			}
+		})
+	}
+}
+
 func newPrePopulatedTree(size int) (*RTree, []Item) {
 	tree := New()
-	items := make([]Item, size)
-	for i := 0; i < size; i++ {
-		items[i] = randomItem()
-	}
+	items := randomItems(size)
 	tree.BulkLoad(items)
 	return tree, items
 }
 
+func randomItems(count int) []Item {
+	items := make([]Item, count)
+	for i := 0; i < count; i++ {
+		items[i] = randomItem()
+	}
+	return items
+}
+
 func randomItem() *testItem {
 	return &testItem{
 		data:   make([]byte, rand.Intn(2048)), // simulate big structs
@@ -71,6 +874,36 @@ func randomItem() *testItem {
 	}
 }
 
+// clusteredItems returns small items that are grouped around a few random cluster centers.
+func clusteredItems(rnd *rand.Rand, count int) []Item {
+	centers := make([]vmath.Vec2f, 20)
+	for i := range centers {
+		centers[i] = vmath.Vec2f{rnd.Float32() * 1000, rnd.Float32() * 1000}
+	}
+	items := make([]Item, count)
+	for i := range items {
+		c := centers[rnd.Intn(len(centers))]
+		pos := c.Add(vmath.Vec2f{float32(rnd.NormFloat64()) * 20, float32(rnd.NormFloat64()) * 20})
+		size := vmath.Vec2f{rnd.Float32() * 2, rnd.Float32() * 2}
+		items[i] = &testItem{bounds: vmath.Rectf{Min: pos, Max: pos.Add(size)}}
+	}
+	return items
+}
+
+// elongatedItems returns long and thin items, which are either horizontal or vertical.
+func elongatedItems(rnd *rand.Rand, count int) []Item {
+	items := make([]Item, count)
+	for i := range items {
+		pos := vmath.Vec2f{rnd.Float32() * 1000, rnd.Float32() * 1000}
+		size := vmath.Vec2f{rnd.Float32()*50 + 1, rnd.Float32() * 0.5}
+		if rnd.Intn(2) == 0 {
+			size = vmath.Vec2f{size[1], size[0]}
+		}
+		items[i] = &testItem{bounds: vmath.Rectf{Min: pos, Max: pos.Add(size)}}
+	}
+	return items
+}
+
 func randomRect() vmath.Rectf {
 	dim := float32(100)
 	return vmath.Rectf{
@@ -0,0 +1,83 @@
+package rtree
+
+// tombstone replaces an item that was marked as removed.
+// It keeps the bounds of the original item, so that the tree structure stays valid until it is compacted.
+type tombstone struct {
+	Item
+}
+
+// isTombstone returns true if the item was marked as removed.
+func isTombstone(item Item) bool {
+	_, ok := item.(tombstone)
+	return ok
+}
+
+// MarkRemoved marks the given item as removed without restructuring the tree.
+// equalsFn is optional. It is useful if you only have a copy of the originally inserted item.
+// Returns false if the item was not found.
+//
+// Marked items are excluded from all queries, but the tree's bounding boxes are not updated.
+// Marking is cheaper than removing, but query performance degrades with the number of marked items.
+// Call Compact to physically remove all marked items.
+func (r *RTree) MarkRemoved(item Item, equalsFn EqualsFunc) bool {
+	r.checkWritable()
+	r.flush()
+	leaf, idx, _ := r.findItem(item, equalsFn)
+	if leaf == nil {
+		return false
+	}
+	leaf.items[idx] = tombstone{leaf.items[idx]}
+	r.tombstones++
+	r.version.Add(1)
+	return true
+}
+
+// Compact physically removes all items that were marked as removed.
+// Empty nodes are removed (unless disabled via SetCondense) and all bounding boxes are updated.
+func (r *RTree) Compact() *RTree {
+	r.checkWritable()
+	r.flush()
+	if r.tombstones == 0 {
+		return r
+	}
+	root := r.root.Load()
+	compactNode(root, r.keepEmptyNodes)
+	if len(root.children)+len(root.items) == 0 && !r.keepEmptyNodes { // tree is empty
+		r.Clear()
+	}
+	r.tombstones = 0
+	r.version.Add(1)
+	return r
+}
+
+// compactNode recursively removes all tombstones from the given subtree.
+// Empty child nodes are removed unless keepEmptyNodes is set. All bounding boxes are updated.
+func compactNode(nod *node, keepEmptyNodes bool) {
+	if nod.leaf {
+		kept := nod.items[:0]
+		for _, item := range nod.items {
+			if !isTombstone(item) {
+				kept = append(kept, item)
+			}
+		}
+		for i := len(kept); i < len(nod.items); i++ {
+			nod.items[i] = nil // allow garbage collection
+		}
+		nod.items = kept
+		calcBBox(nod)
+		return
+	}
+
+	kept := nod.children[:0]
+	for _, child := range nod.children {
+		compactNode(child, keepEmptyNodes)
+		if len(child.children)+len(child.items) > 0 || keepEmptyNodes {
+			kept = append(kept, child)
+		}
+	}
+	for i := len(kept); i < len(nod.children); i++ {
+		nod.children[i] = nil // allow garbage collection
+	}
+	nod.children = kept
+	calcBBox(nod)
+}
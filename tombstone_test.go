@@ -0,0 +1,31 @@
+package rtree
+
+import (
+	"testing"
+
+	"github.com/maja42/vmath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkRemoved(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	removed, kept := items[:300], items[300:]
+
+	for _, item := range removed {
+		assert.True(t, tree.MarkRemoved(item, nil))
+	}
+	assert.False(t, tree.MarkRemoved(removed[0], nil))
+
+	assert.Equal(t, len(kept), tree.Size())
+	assert.ElementsMatch(t, kept, tree.All())
+	for _, item := range removed {
+		assert.NotContains(t, tree.Search(item.Bounds(), false), item)
+	}
+	pos := vmath.Vec2f{50, 50}
+	assert.Equal(t, bruteForceSqDistances(kept, pos)[0], tree.NearestNeighbor(pos).Bounds().SquarePointDistance(pos))
+
+	tree.Compact()
+	assert.Equal(t, len(kept), tree.Size())
+	assert.ElementsMatch(t, kept, tree.All())
+	assertTreeBounds(t, tree)
+}
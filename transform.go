@@ -0,0 +1,154 @@
+package rtree
+
+import (
+	"sort"
+
+	"github.com/maja42/vmath"
+)
+
+// Translate moves the bounding boxes of all tree nodes by the given delta.
+// The tree structure is not modified, which is much faster than rebuilding the tree.
+//
+// The items themselves are not modified. The caller must move all items by the same delta
+// (before any further tree operation) so that their bounds stay consistent with the tree.
+func (r *RTree) Translate(delta vmath.Vec2f) *RTree {
+	return r.Transform(func(bounds vmath.Rectf) vmath.Rectf {
+		return bounds.Add(delta)
+	})
+}
+
+// Scale scales the bounding boxes of all tree nodes about the given origin.
+// The tree structure is not modified, which is much faster than rebuilding the tree.
+// Panics if the factor is not positive (or NaN).
+//
+// The items themselves are not modified. The caller must scale all items the same way
+// (before any further tree operation) so that their bounds stay consistent with the tree.
+func (r *RTree) Scale(origin vmath.Vec2f, factor float32) *RTree {
+	if !(factor > 0) { // also rejects NaN
+		panic("rtree: scale factor must be positive")
+	}
+	return r.Transform(func(bounds vmath.Rectf) vmath.Rectf {
+		return vmath.Rectf{
+			Min: bounds.Min.Sub(origin).MulScalar(factor).Add(origin),
+			Max: bounds.Max.Sub(origin).MulScalar(factor).Add(origin),
+		}
+	})
+}
+
+// Transform applies the given function to the bounding boxes of all tree nodes.
+// The tree structure is not modified, which is much faster than rebuilding the tree.
+//
+// The function must be applicable to the bounds of items as well,
+// and must keep the relation between bounding boxes intact: If a box contains another one, it must still do so afterwards.
+// This is true for translations and positive scaling, but not for rotations.
+//
+// The items themselves are not modified. The caller must transform all items the same way
+// (before any further tree operation) so that their bounds stay consistent with the tree.
+// If leaves are kept sorted (see SetSortedLeaves), their items are re-sorted by their transformed bounds.
+func (r *RTree) Transform(fn func(bounds vmath.Rectf) vmath.Rectf) *RTree {
+	r.checkWritable()
+	r.flush()
+	root := r.root.Load()
+	if len(root.children)+len(root.items) == 0 {
+		return r // keep the bounds of empty trees
+	}
+
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = root
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+		node.bounds = fn(node.bounds)
+		if r.sortedLeaves && node.leaf {
+			sortTransformedItems(node.items, fn)
+		}
+	}
+	r.version.Add(1)
+	return r
+}
+
+// sortTransformedItems sorts the items by the min. x-coordinate of their transformed bounds.
+// Transformations like mirroring change the order, while translations and positive scaling keep it.
+func sortTransformedItems(items []Item, fn func(bounds vmath.Rectf) vmath.Rectf) {
+	keys := make([]float32, len(items))
+	sorted := true
+	for i, item := range items {
+		keys[i] = fn(item.Bounds()).Min[0]
+		if i > 0 && keys[i] < keys[i-1] {
+			sorted = false
+		}
+	}
+	if !sorted {
+		sort.Sort(itemsByKey{items, keys})
+	}
+}
+
+// itemsByKey sorts items by precomputed keys.
+type itemsByKey struct {
+	items []Item
+	keys  []float32
+}
+
+func (a itemsByKey) Len() int { return len(a.items) }
+func (a itemsByKey) Swap(i, j int) {
+	a.items[i], a.items[j] = a.items[j], a.items[i]
+	a.keys[i], a.keys[j] = a.keys[j], a.keys[i]
+}
+func (a itemsByKey) Less(i, j int) bool { return a.keys[i] < a.keys[j] }
+
+// TransformItems replaces every item with the result of the given function and rebuilds the tree.
+// In contrast to Transform, this works with arbitrary transformations (like rotations),
+// but is as expensive as bulk-loading all items again.
+// Returns the number of transformed items.
+func (r *RTree) TransformItems(fn func(item Item) Item) int {
+	items := r.All()
+	for i, item := range items {
+		items[i] = fn(item)
+	}
+	r.Clear()
+	r.BulkLoad(items)
+	return len(items)
+}
+
+// Reindex updates the tree after items changed their bounds in-place.
+// This is the supported way to handle item mutations without removing and re-inserting the items.
+//
+// If all items are still within the bounding boxes of their leaf nodes, only the bounding boxes are recalculated.
+// Otherwise, the tree is rebuilt to keep query performance high, and true is returned.
+func (r *RTree) Reindex() (rebuilt bool) {
+	r.checkWritable()
+	r.flush()
+
+	moved := false
+	nodesToSearch := make([]*node, 1)
+	nodesToSearch[0] = r.root.Load()
+	for len(nodesToSearch) > 0 && !moved {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+		for _, item := range node.items {
+			if !node.bounds.ContainsRectf(item.Bounds()) {
+				moved = true
+				break
+			}
+		}
+	}
+
+	if moved {
+		r.Rebuild()
+		return true
+	}
+	recalcBBoxes(r.root.Load())
+	if r.sortedLeaves {
+		sortLeaves(r.root.Load())
+	}
+	r.version.Add(1)
+	return false
+}
+
+// recalcBBoxes recalculates the bounding boxes of all nodes within the subtree, bottom-up.
+func recalcBBoxes(nod *node) {
+	for _, child := range nod.children {
+		recalcBBoxes(child)
+	}
+	calcBBox(nod)
+}
@@ -0,0 +1,150 @@
+package rtree
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/maja42/vmath"
+	"github.com/maja42/vmath/math32"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslate(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	delta := vmath.Vec2f{-30, 12}
+
+	tree.Translate(delta)
+	for _, item := range items {
+		item := item.(*testItem)
+		item.bounds = item.bounds.Add(delta)
+	}
+
+	assertTreeBounds(t, tree)
+	for _, item := range items {
+		assert.Contains(t, tree.Search(item.Bounds(), true), item)
+	}
+}
+
+func TestScale(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	origin := vmath.Vec2f{20, 50}
+
+	tree.Scale(origin, 2)
+	for _, item := range items {
+		item := item.(*testItem)
+		item.bounds = vmath.Rectf{
+			Min: item.bounds.Min.Sub(origin).MulScalar(2).Add(origin),
+			Max: item.bounds.Max.Sub(origin).MulScalar(2).Add(origin),
+		}
+	}
+
+	assertTreeBounds(t, tree)
+	for _, item := range items {
+		assert.Contains(t, tree.Search(item.Bounds(), true), item)
+	}
+
+	assert.Panics(t, func() { tree.Scale(origin, 0) })
+	assert.Panics(t, func() { tree.Scale(origin, -1) })
+	assert.Panics(t, func() { tree.Scale(origin, math32.NaN()) })
+}
+
+func TestTransform_SortedLeaves(t *testing.T) {
+	items := randomItems(1000)
+	tree := New().SetSortedLeaves(true).BulkLoad(items)
+	mirror := func(bounds vmath.Rectf) vmath.Rectf {
+		return vmath.Rectf{
+			Min: vmath.Vec2f{-bounds.Max[0], bounds.Min[1]},
+			Max: vmath.Vec2f{-bounds.Min[0], bounds.Max[1]},
+		}
+	}
+
+	tree.Transform(mirror)
+	for _, item := range items {
+		item := item.(*testItem)
+		item.bounds = mirror(item.bounds)
+	}
+
+	assertTreeBounds(t, tree)
+	assertLeavesSorted(t, tree.root.Load())
+	unsorted := New().BulkLoad(items)
+	for _, r := range [][2]float32{{-25, -20}, {-100, 0}, {-50, -50}} {
+		assert.ElementsMatch(t, unsorted.RangeX(r[0], r[1]), tree.RangeX(r[0], r[1]))
+	}
+}
+
+func TestTransformItems(t *testing.T) {
+	tree, _ := newPrePopulatedTree(1000)
+
+	rotate := func(v vmath.Vec2f) vmath.Vec2f {
+		return vmath.Vec2f{-v[1], v[0]}
+	}
+	cnt := tree.TransformItems(func(item Item) Item {
+		b := item.Bounds()
+		return &testItem{bounds: vmath.RectfFromCorners(rotate(b.Min), rotate(b.Max))}
+	})
+	assert.Equal(t, 1000, cnt)
+	assert.Equal(t, 1000, tree.Size())
+
+	assertTreeBounds(t, tree)
+	for _, item := range tree.All() {
+		assert.Less(t, item.Bounds().Min[0], float32(0))
+		assert.Contains(t, tree.Search(item.Bounds(), true), item)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+
+	// shrink all items; they stay within their leaf nodes
+	for _, item := range items {
+		item := item.(*testItem)
+		item.bounds.Max = item.bounds.Min.Add(item.bounds.Size().MulScalar(0.5))
+	}
+	assert.False(t, tree.Reindex())
+	assertTreeBounds(t, tree)
+
+	// move all items
+	for _, item := range items {
+		item := item.(*testItem)
+		item.bounds = item.bounds.Add(vmath.Vec2f{rand.Float32() * 50, 0})
+	}
+	assert.True(t, tree.Reindex())
+	assertTreeBounds(t, tree)
+	for _, item := range items {
+		assert.Contains(t, tree.Search(item.Bounds(), true), item)
+	}
+}
+
+// assertTreeBounds checks that the bounds of all nodes are exactly the bounds of their children.
+func assertTreeBounds(t *testing.T, tree *RTree) {
+	t.Helper()
+	nodesToSearch := []*node{tree.root.Load()}
+	for len(nodesToSearch) > 0 {
+		node := popNode(&nodesToSearch)
+		nodesToSearch = append(nodesToSearch, node.children...)
+		if len(node.children)+len(node.items) == 0 {
+			continue
+		}
+		assert.Equal(t, calcSubBBox(node, 0, len(node.children)+len(node.items)), node.bounds)
+	}
+}
+
+func TestRefit(t *testing.T) {
+	tree, items := newPrePopulatedTree(1000)
+	moved := items[10].(*testItem)
+
+	// small movement
+	moved.bounds = moved.bounds.Add(vmath.Vec2f{0.5, -0.5})
+	assert.True(t, tree.Refit(moved, nil))
+	assert.Contains(t, tree.Search(moved.bounds, true), moved)
+	assertTreeBounds(t, tree)
+
+	// far outside of the item's leaf
+	moved.bounds = vmath.Rectf{Min: vmath.Vec2f{500, 500}, Max: vmath.Vec2f{501, 501}}
+	assert.True(t, tree.Refit(moved, nil))
+	assert.Equal(t, []Item{moved}, tree.SearchPos(vmath.Vec2f{500.5, 500.5}))
+	assertTreeBounds(t, tree)
+
+	assert.False(t, tree.Refit(randomItem(), nil))
+	assert.ElementsMatch(t, items, tree.All())
+}